@@ -1,13 +1,22 @@
 package models
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/squirrel-land/models/mobilityManagers/grpcUpdatablePositions"
 	"github.com/squirrel-land/models/mobilityManagers/interactivePositions"
 	"github.com/squirrel-land/models/mobilityManagers/staticDefinedPositions"
 	"github.com/squirrel-land/models/mobilityManagers/staticUniformPositions"
+	"github.com/squirrel-land/models/septembers/burstLoss"
+	"github.com/squirrel-land/models/septembers/composite"
 	"github.com/squirrel-land/models/septembers/csmaca"
 	"github.com/squirrel-land/models/septembers/distanceBased"
+	"github.com/squirrel-land/models/septembers/faultInjection"
+	"github.com/squirrel-land/models/septembers/linkMatrix"
+	"github.com/squirrel-land/models/septembers/logDistance"
 	"github.com/squirrel-land/models/septembers/passThrough"
+	"github.com/squirrel-land/models/septembers/twoRayGround"
 	"github.com/squirrel-land/squirrel"
 )
 
@@ -19,12 +28,43 @@ var MobilityManagers = map[string]func() squirrel.MobilityManager{
 }
 
 var Septembers = map[string]func() squirrel.September{
-	"PassThrough":   passThrough.CreateSeptember,
-	"DistanceBased": distanceBased.CreateSeptember,
-	"CSMA/CA":       csmaca.CreateSeptember,
+	"PassThrough":            passThrough.CreateSeptember,
+	"DistanceBased":          distanceBased.CreateSeptember,
+	"CSMA/CA":                csmaca.CreateSeptember,
+	"LogDistance":            logDistance.CreateSeptember,
+	"TwoRayGround":           twoRayGround.CreateSeptember,
+	"LinkMatrix":             linkMatrix.CreateSeptember,
+	"RateLimitedPassThrough": passThrough.CreateRateLimitedSeptember,
 
 	/* legacy names */
 	"September0th": passThrough.CreateSeptember,
 	"September1st": distanceBased.CreateSeptember,
 	"September2nd": csmaca.CreateSeptember,
 }
+
+// Septembers that wrap other septembers look them up in Septembers, so they
+// can only be added once it's initialized.
+func init() {
+	Septembers["BurstLoss"] = func() squirrel.September { return burstLoss.CreateSeptember(NewSeptember) }
+	Septembers["Composite"] = func() squirrel.September { return composite.CreateSeptember(NewSeptember, SeptemberNames) }
+	Septembers["FaultInjection"] = func() squirrel.September { return faultInjection.CreateSeptember(NewSeptember) }
+}
+
+// NewSeptember creates a september by its name in Septembers.
+func NewSeptember(name string) (squirrel.September, error) {
+	create, ok := Septembers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown september: %s", name)
+	}
+	return create(), nil
+}
+
+// SeptemberNames returns names in Septembers, sorted.
+func SeptemberNames() []string {
+	names := make([]string, 0, len(Septembers))
+	for name := range Septembers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,198 @@
+// Package httpAccess controls access to HTTP APIs that models serve: who may
+// read and who may change things, and which interfaces they listen on.
+package httpAccess
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+const ParametersHelp = `
+  "public":             bool, optional, default false;
+                        Listen on all interfaces when the address of the HTTP
+                        API has no host part; otherwise only localhost is
+                        listened on.
+  "editor_token":       string, optional;
+                        Token that allows changes through the HTTP API. Given
+                        as the password of HTTP basic auth, or as a bearer
+                        token.
+  "viewer_token":       string, optional;
+                        Token that allows reading but not changing.
+  "anonymous_role":     string, optional;
+                        Role of requests without a token; one of "none",
+                        "viewer" or "editor". Defaults to "editor" if no token
+                        is configured, or "none" otherwise. Changes also need
+                        an X-Requested-With header, against cross-site
+                        requests from browsers.
+`
+
+type role int
+
+const (
+	roleNone role = iota
+	roleViewer
+	roleEditor
+)
+
+var roleNames = map[string]role{
+	"none":   roleNone,
+	"viewer": roleViewer,
+	"editor": roleEditor,
+}
+
+func (r role) String() string {
+	for name, v := range roleNames {
+		if v == r {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// Access decides what a request is allowed to do. Tokens are given either as
+// the password of HTTP basic auth (username is ignored) or as a bearer token.
+type Access struct {
+	editorToken string
+	viewerToken string
+	anonymous   role
+}
+
+// Configure reads the parameters in ParametersHelp from conf. It also makes
+// laddr listen on localhost only, unless public is set.
+func Configure(conf *etcd.Node, laddr string) (a *Access, addr string, err error) {
+	a = &Access{}
+	var public bool
+	anonymous := ""
+	for _, node := range conf.Nodes {
+		if node.Dir {
+			continue
+		}
+		if strings.HasSuffix(node.Key, "/editor_token") {
+			a.editorToken = node.Value
+		} else if strings.HasSuffix(node.Key, "/viewer_token") {
+			a.viewerToken = node.Value
+		} else if strings.HasSuffix(node.Key, "/anonymous_role") {
+			anonymous = node.Value
+		} else if strings.HasSuffix(node.Key, "/public") {
+			public, err = strconv.ParseBool(node.Value)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	if anonymous == "" {
+		// stay compatible with configs that have no tokens at all
+		if a.editorToken == "" && a.viewerToken == "" {
+			a.anonymous = roleEditor
+		} else {
+			a.anonymous = roleNone
+		}
+	} else {
+		var ok bool
+		if a.anonymous, ok = roleNames[anonymous]; !ok {
+			err = errors.New("anonymous_role has to be one of none, viewer or editor")
+			return
+		}
+	}
+
+	host, port, err := net.SplitHostPort(laddr)
+	if err != nil {
+		return
+	}
+	if host == "" && !public {
+		host = "127.0.0.1"
+	}
+	addr = net.JoinHostPort(host, port)
+	return
+}
+
+func tokenEqual(given, expected string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+func (a *Access) roleOf(req *http.Request) role {
+	var token string
+	if _, password, ok := req.BasicAuth(); ok {
+		token = password
+	} else if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	} else {
+		return a.anonymous
+	}
+	switch {
+	case tokenEqual(token, a.editorToken):
+		return roleEditor
+	case tokenEqual(token, a.viewerToken):
+		return roleViewer
+	}
+	return roleNone
+}
+
+// sameOrigin protects state changing requests from CSRF. Browsers don't send
+// X-Requested-With cross-origin without a CORS preflight, which we never
+// allow; Origin, if present, has to match the host being talked to.
+func sameOrigin(req *http.Request) bool {
+	if req.Header.Get("X-Requested-With") == "" {
+		return false
+	}
+	if origin := req.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != req.Host {
+			return false
+		}
+	}
+	return true
+}
+
+// Wrap returns a handler that serves requests with handler as far as their role
+// allows, and tells the role at /whoami. realm is shown when asking for
+// credentials.
+func (a *Access) Wrap(realm string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r := a.roleOf(req)
+		if req.URL.Path == "/whoami" {
+			json.NewEncoder(w).Encode(struct{ Role string }{r.String()})
+			return
+		}
+		if r == roleNone {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+			http.Error(w, "unauthorized", 401)
+			return
+		}
+		if req.Method != "GET" && req.Method != "HEAD" {
+			if r != roleEditor {
+				http.Error(w, "read-only access", 403)
+				return
+			}
+			if !sameOrigin(req) {
+				http.Error(w, "cross-origin request rejected", 403)
+				return
+			}
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// Serve listens on addr, as returned by Configure, and serves requests with
+// handler wrapped by Wrap in the background. It only returns an error if addr
+// can't be listened on; later errors are logged.
+func (a *Access) Serve(addr string, realm string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		log.Printf("serving %s error: %s", realm, http.Serve(ln, a.Wrap(realm, handler)).Error())
+	}()
+	return nil
+}
@@ -1,113 +1,425 @@
-function draw_grid(canvas, size) {
+var view = null;       // world bounds, scale and background; from /config
+var zoom = 1;
+var pan = {x: 0, y: 0}; // pixels
+var background = null; // fabric.Image of the floor plan, if any
+var paths = [];        // paths that nodes are moving along; from /paths
+var drawing = null;    // the path being drawn, if any
+var readOnly = false;  // viewers can watch but not move nodes
 
-  var width = canvas.width;
-  var height = canvas.height;
+function world2pix(x, y) {
+  return {
+    x: (x - view.MinX) * view.PixelsPerMeter * zoom + pan.x,
+    y: (y - view.MinY) * view.PixelsPerMeter * zoom + pan.y
+  };
+}
 
-  var j = 0;
-  var line = null;
-  var rect = [];
+function pix2world(x, y) {
+  return {
+    x: (x - pan.x) / (view.PixelsPerMeter * zoom) + view.MinX,
+    y: (y - pan.y) / (view.PixelsPerMeter * zoom) + view.MinY
+  };
+}
 
-  console.log(width + ":" + height);
+function units2m(units) {
+  return units / view.UnitsPerMeter;
+}
 
-  for (var i = 0; i < Math.ceil(width / size); ++i) {
-    rect[0] = i * size;
-    rect[1] = 0;
+function m2units(m) {
+  return m * view.UnitsPerMeter;
+}
 
-    rect[2] = i * size;
-    rect[3] = height;
+function clamp(v, min, max) {
+  return Math.min(Math.max(v, min), max);
+}
 
-    line = null;
-    line = new fabric.Line(rect, {
-      stroke: '#999',
-      opacity: 0.5,
-    });
+function add_line(canvas, rect, stroke) {
+  var line = new fabric.Line(rect, {
+    stroke: stroke || '#999',
+    opacity: 0.5,
+  });
+  line.selectable = false;
+  canvas.add(line);
+  line.sendToBack();
+}
 
-    line.selectable = false;
-    canvas.add(line);
-    line.sendToBack();
+function draw_grid(canvas, spacing) {
+  var topLeft = world2pix(view.MinX, view.MinY);
+  var bottomRight = world2pix(view.MaxX, view.MaxY);
 
+  for (var x = Math.ceil(view.MinX / spacing) * spacing; x <= view.MaxX; x += spacing) {
+    var px = world2pix(x, 0).x;
+    add_line(canvas, [px, topLeft.y, px, bottomRight.y]);
   }
 
-  for (i = 0; i < Math.ceil(height / size); ++i) {
-    rect[0] = 0;
-    rect[1] = i * size;
-
-    rect[2] = width;
-    rect[3] = i * size;
-
-    line = null;
-    line = new fabric.Line(rect, {
-      stroke: '#999',
-      opacity: 0.5,
-    });
-    line.selectable = false;
-    canvas.add(line);
-    line.sendToBack();
-
+  for (var y = Math.ceil(view.MinY / spacing) * spacing; y <= view.MaxY; y += spacing) {
+    var py = world2pix(0, y).y;
+    add_line(canvas, [topLeft.x, py, bottomRight.x, py]);
   }
-
-}
-
-function mm2pix(mm) {
-  return m2pix(mm / 1000);
 }
 
-function m2pix(m) {
-  return m * 5
+function draw_background(canvas) {
+  if (background === null) {
+    return;
+  }
+  var bg = view.Background;
+  var topLeft = world2pix(bg.Left, bg.Top);
+  var bottomRight = world2pix(bg.Right, bg.Bottom);
+  background.set({
+    originX: 'left',
+    originY: 'top',
+    left: topLeft.x,
+    top: topLeft.y,
+    scaleX: (bottomRight.x - topLeft.x) / background.width,
+    scaleY: (bottomRight.y - topLeft.y) / background.height,
+  });
+  background.selectable = false;
+  canvas.add(background);
+  background.sendToBack();
 }
 
-function pix2mm(pix) {
-  return pix * 1000 / 5;
+function draw_path(canvas, waypoints, stroke) {
+  for (var i = 1; i < waypoints.length; i++) {
+    var a = world2pix(units2m(waypoints[i-1].X), units2m(waypoints[i-1].Y));
+    var b = world2pix(units2m(waypoints[i].X), units2m(waypoints[i].Y));
+    add_line(canvas, [a.x, a.y, b.x, b.y], stroke);
+  }
 }
 
 function fabricInit() {
   var canvas = new fabric.Canvas('c');
-  canvas.setHeight(700);
-  canvas.setWidth(1000);
+  canvas.selection = false; // dragging on empty space pans, unless in select mode
+  canvas.setHeight((view.MaxY - view.MinY) * view.PixelsPerMeter);
+  canvas.setWidth((view.MaxX - view.MinX) * view.PixelsPerMeter);
   return canvas;
 }
 
 function render(canvas, data) {
   canvas.clear();
-  draw_grid(canvas, m2pix(10)); // 10 meters per cell
+  draw_grid(canvas, view.GridSpacing);
+  draw_background(canvas);
+  paths.forEach(function(path) {
+    draw_path(canvas, path.Waypoints, '#00f');
+  });
+  if (drawing !== null) {
+    draw_path(canvas, drawing.Waypoints, '#f00');
+  }
 
   var rainbow = ["#ffcc00", "#ccff00", "#00ccff", "#ff0000", "#ffff00"];
   for (var i=0; i < data.length; i++) {
-    var text = new fabric.Text(String(data[i].I), {fontSize: 16, fill: 'black'});
-    var circle = new fabric.Circle({radius: 10, fill: rainbow[i % rainbow.length]});
-    var group = new fabric.Group([circle, text], {
-      left: mm2pix(data[i].X), top: mm2pix(data[i].Y)
+    var p = world2pix(units2m(data[i].X), units2m(data[i].Y));
+    var objects = [
+      new fabric.Circle({radius: 10, fill: data[i].Enabled ? rainbow[i % rainbow.length] : '#ccc'}),
+      new fabric.Text(String(data[i].I), {fontSize: 16, fill: 'black'}),
+    ];
+    if (data[i].Addr) {
+      objects.push(new fabric.Text(data[i].Addr, {fontSize: 10, fill: '#333', top: 18}));
+    }
+    var group = new fabric.Group(objects, {
+      left: p.x, top: p.y
     });
-    group.nodeData=data[i]
+    group.hasControls = false;
+    group.selectable = data[i].Enabled && !readOnly;
+    group.nodeData = data[i];
 
     canvas.add(group);
   }
 
+  canvas.renderAll();
+}
+
+// selected returns nodes that are currently selected on canvas, either alone
+// or as a group, along with their absolute positions in pixels.
+function selected(canvas) {
+  var ret = [];
+  var group = canvas.getActiveGroup();
+  if (group) {
+    group.getObjects().forEach(function(obj) {
+      ret.push({node: obj.nodeData, x: group.left + obj.left, y: group.top + obj.top});
+    });
+  } else if (canvas.getActiveObject() && canvas.getActiveObject().nodeData) {
+    var obj = canvas.getActiveObject();
+    ret.push({node: obj.nodeData, x: obj.left, y: obj.top});
+  }
+  return ret;
+}
+
+function showSelection(canvas) {
+  var sel = selected(canvas);
+  $('#edit-nodes').val(sel.map(function(s) { return s.node.I; }).join(','));
+  if (sel.length === 1) {
+    $('#edit-x').val(units2m(sel[0].node.X));
+    $('#edit-y').val(units2m(sel[0].node.Y));
+    $('#edit-h').val(units2m(sel[0].node.H));
+  } else {
+    $('#edit-x').val('');
+    $('#edit-y').val('');
+    $('#edit-h').val('');
+  }
+}
+
+function bind(canvas, data) {
   var canvasOnChange = function(options) {
-    options.target.setCoords();
+    var target = options.target;
+    if (target.nodeData === undefined) {
+      // a group of selected nodes is being moved
+      var moved = [];
+      selected(canvas).forEach(function(s) {
+        var p = pix2world(s.x, s.y);
+        s.node.X = m2units(clamp(p.x, view.MinX, view.MaxX));
+        s.node.Y = m2units(clamp(p.y, view.MinY, view.MaxY));
+        moved.push(s.node);
+      });
+      $.post('setBulk', JSON.stringify(moved));
+      return;
+    }
+
+    var p = pix2world(target.left, target.top);
+    p.x = clamp(p.x, view.MinX, view.MaxX);
+    p.y = clamp(p.y, view.MinY, view.MaxY);
+    var clamped = world2pix(p.x, p.y);
+    target.set({left: clamped.x, top: clamped.y});
+    target.setCoords();
+
     canvas.forEachObject(function(obj) {
-      if (obj === options.target) return;
-      obj.setOpacity(options.target.intersectsWithObject(obj) ? 0.5 : 1);
-      node = options.target.nodeData
-      node.X = pix2mm(options.target.left);
-      node.Y = pix2mm(options.target.top);
-      $.post('set', JSON.stringify(node));
+      if (obj === target || obj.nodeData === undefined) return;
+      obj.setOpacity(target.intersectsWithObject(obj) ? 0.5 : 1);
     });
-  }
+
+    var node = target.nodeData;
+    node.X = m2units(p.x);
+    node.Y = m2units(p.y);
+    $.post('set', JSON.stringify(node));
+  };
 
   canvas.on({
     'object:moving': canvasOnChange,
     'object:scaling': canvasOnChange,
     'object:rotating': canvasOnChange,
+    'object:selected': function() { showSelection(canvas); },
+    'selection:created': function() { showSelection(canvas); },
+    'selection:cleared': function() { showSelection(canvas); },
   });
 
-  canvas.renderAll();
+  // Dragging on empty space pans the view.
+  var panning = null;
+  canvas.on('mouse:down', function(options) {
+    if (canvas.selection || drawing !== null) return;
+    if (options.target === undefined || options.target.nodeData === undefined) {
+      panning = {x: options.e.clientX, y: options.e.clientY};
+    }
+  });
+  canvas.on('mouse:move', function(options) {
+    if (panning === null) return;
+    pan.x += options.e.clientX - panning.x;
+    pan.y += options.e.clientY - panning.y;
+    panning = {x: options.e.clientX, y: options.e.clientY};
+    render(canvas, data);
+  });
+  canvas.on('mouse:up', function() {
+    panning = null;
+  });
+
+  // The mouse wheel zooms around the pointer.
+  $(canvas.upperCanvasEl).on('wheel mousewheel DOMMouseScroll', function(e) {
+    var oe = e.originalEvent;
+    var delta = oe.deltaY || -oe.wheelDelta || oe.detail;
+    var offset = $(canvas.upperCanvasEl).offset();
+    zoomAt(canvas, data, delta < 0 ? 1.25 : 0.8,
+      oe.pageX - offset.left, oe.pageY - offset.top);
+    e.preventDefault();
+  });
+
+  $('#zoom-in').click(function() {
+    zoomAt(canvas, data, 1.25, canvas.width / 2, canvas.height / 2);
+  });
+  $('#zoom-out').click(function() {
+    zoomAt(canvas, data, 0.8, canvas.width / 2, canvas.height / 2);
+  });
+  $('#zoom-reset').click(function() {
+    zoom = 1;
+    pan = {x: 0, y: 0};
+    render(canvas, data);
+  });
+
+  $('#select-mode').change(function() {
+    canvas.selection = this.checked;
+  });
+
+  // Typed coordinates are in meters; empty fields are left untouched.
+  $('#edit-apply').click(function() {
+    var indices = $('#edit-nodes').val().split(',').map(function(s) {
+      return parseInt(s, 10);
+    });
+    var fields = {X: $('#edit-x').val(), Y: $('#edit-y').val(), H: $('#edit-h').val()};
+    var changed = [];
+    data.forEach(function(node) {
+      if (indices.indexOf(node.I) < 0) return;
+      for (var k in fields) {
+        if (fields[k] !== '' && !isNaN(parseFloat(fields[k]))) {
+          node[k] = m2units(parseFloat(fields[k]));
+        }
+      }
+      changed.push(node);
+    });
+    if (changed.length === 0) return;
+    $.post('setBulk', JSON.stringify(changed));
+    render(canvas, data);
+  });
+}
+
+function zoomAt(canvas, data, factor, px, py) {
+  var p = pix2world(px, py);
+  zoom = clamp(zoom * factor, 0.1, 50);
+  var moved = world2pix(p.x, p.y);
+  pan.x += px - moved.x;
+  pan.y += py - moved.y;
+  render(canvas, data);
+}
+
+function refresh(canvas, data) {
+  $.getJSON('list', function(fresh) {
+    data.length = 0;
+    Array.prototype.push.apply(data, fresh);
+    render(canvas, data);
+  });
+}
+
+function bindSession(canvas, data) {
+  var scrubbing = false;
+
+  $('#session-record').click(function() {
+    $.post('session/record');
+  });
+  $('#session-stop').click(function() {
+    $.post('session/stop');
+  });
+  $('#session-play').click(function() {
+    $.post('session/play', {
+      t: $('#session-timeline').val(),
+      speed: $('#session-speed').val(),
+    });
+  });
+  $('#session-timeline').on('mousedown', function() {
+    scrubbing = true;
+  }).on('change', function() {
+    scrubbing = false;
+    $.post('session/seek', {t: this.value}, function() {
+      refresh(canvas, data);
+    });
+  });
+  $('#session-load').change(function() {
+    var reader = new FileReader();
+    reader.onload = function() {
+      $.post('session/load', reader.result, function() {
+        $.post('session/seek', {t: 0}, function() {
+          refresh(canvas, data);
+        });
+      });
+    };
+    reader.readAsText(this.files[0]);
+  });
+
+  setInterval(function() {
+    $.getJSON('session/status', function(status) {
+      var text = (status.Position / 1000).toFixed(1) + ' / ' + (status.Duration / 1000).toFixed(1) + ' s';
+      if (status.Recording) {
+        text = 'recording ' + text;
+      } else if (status.Replaying) {
+        text = 'replaying ' + text;
+        refresh(canvas, data);
+      }
+      $('#session-time').text(text);
+      if (!scrubbing) {
+        $('#session-timeline').attr('max', status.Duration).val(status.Position);
+      }
+    });
+  }, 500);
 }
 
+function bindPaths(canvas, data) {
+  // While drawing, every click on the canvas adds a waypoint.
+  canvas.on('mouse:down', function(options) {
+    if (drawing === null) return;
+    var pointer = canvas.getPointer(options.e);
+    var p = pix2world(pointer.x, pointer.y);
+    var last = drawing.Waypoints[drawing.Waypoints.length - 1];
+    drawing.Waypoints.push({
+      X: m2units(clamp(p.x, view.MinX, view.MaxX)),
+      Y: m2units(clamp(p.y, view.MinY, view.MaxY)),
+      H: last.H,
+    });
+    render(canvas, data);
+  });
+
+  $('#path-draw').click(function() {
+    var sel = selected(canvas);
+    if (sel.length !== 1) {
+      alert('Select exactly one node to draw a path for.');
+      return;
+    }
+    var node = sel[0].node;
+    drawing = {I: node.I, Waypoints: [{X: node.X, Y: node.Y, H: node.H}]};
+    canvas.deactivateAll();
+  });
+  $('#path-finish').click(function() {
+    if (drawing === null) return;
+    drawing.Speed = parseFloat($('#path-speed').val());
+    drawing.Mode = $('#path-mode').val();
+    $.post('path', JSON.stringify(drawing)).fail(function(xhr) {
+      alert(xhr.responseText);
+    });
+    drawing = null;
+    render(canvas, data);
+  });
+  $('#path-cancel').click(function() {
+    drawing = null;
+    selected(canvas).forEach(function(s) {
+      $.post('path/cancel', {i: s.node.I});
+    });
+    render(canvas, data);
+  });
+
+  setInterval(function() {
+    $.getJSON('paths', function(fresh) {
+      var wasMoving = paths.length !== 0;
+      paths = fresh;
+      if (wasMoving || paths.length !== 0) {
+        refresh(canvas, data);
+      }
+    });
+  }, 500);
+}
 
 function fetchData() {
-  $.getJSON('list', function(data){
-    render(fabricInit(), data);
+  $.getJSON('whoami', function(who) {
+    readOnly = who.Role !== 'editor';
+    if (readOnly) {
+      $('.editor').hide();
+      $('#session-timeline').prop('disabled', true);
+    }
+  });
+  $.getJSON('config', function(config) {
+    view = config;
+    $.getJSON('list', function(data) {
+      var canvas = fabricInit();
+      bind(canvas, data);
+      bindSession(canvas, data);
+      bindPaths(canvas, data);
+      render(canvas, data);
+      // nobody drags nodes on a read-only page, so it is safe to keep it
+      // up to date all the time
+      setInterval(function() {
+        if (readOnly) {
+          refresh(canvas, data);
+        }
+      }, 1000);
+      if (view.Background !== null) {
+        fabric.Image.fromURL(view.Background.URL, function(img) {
+          background = img;
+          render(canvas, data);
+        });
+      }
+    });
   });
 }
 
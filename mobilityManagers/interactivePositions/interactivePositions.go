@@ -3,12 +3,14 @@ package interactivePositions
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"os/exec"
 	"path"
 	"strings"
 
 	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/httpAccess"
 	"github.com/squirrel-land/squirrel"
 )
 
@@ -16,14 +18,48 @@ type interactivePositions struct {
 	positionManager squirrel.PositionManager
 	newPositions    chan *squirrel.Position
 	laddr           string
+
+	view           *JSView
+	backgroundPath string
+
+	sessions *sessions
+	paths    *paths
+	access   *httpAccess.Access
 }
 
 func NewInteractivePositions() squirrel.MobilityManager {
-	return &interactivePositions{newPositions: make(chan *squirrel.Position)}
+	return &interactivePositions{
+		newPositions: make(chan *squirrel.Position),
+		view:         defaultView(),
+	}
 }
 
 func (m *interactivePositions) ParametersHelp() string {
-	return ``
+	return `InteractivePositions is a mobility manager that serves a web page, on which
+nodes can be dragged around. Positions are in millimeters unless
+units_per_meter says otherwise; all other lengths are in meters.
+
+  "laddr":             string, required;
+                       The TCP address that the web UI should listen on. If
+                       the host part is empty, only localhost is listened on
+                       unless "public" is true. The editor_token allows
+                       moving nodes; the viewer_token only watching them.
+  "min_x", "min_y":    float64, optional, default 0, 0;
+                       Top-left corner of the world shown in the UI.
+  "max_x", "max_y":    float64, optional, default 200, 140;
+                       Bottom-right corner of the world shown in the UI.
+  "pixels_per_meter":  float64, optional, default 5;
+                       Scale of the canvas at zoom level 1.
+  "grid_spacing":      float64, optional, default 10;
+                       Distance between grid lines.
+  "units_per_meter":   float64, optional, default 1000;
+                       Number of position units in one meter.
+  "background_image":  string, optional;
+                       Path to a floor-plan or map image drawn under nodes.
+  "background_left", "background_top", "background_right", "background_bottom":
+                       float64, optional, default to world bounds;
+                       World coordinates of the background image's edges.` +
+		httpAccess.ParametersHelp + `    `
 }
 
 func (m *interactivePositions) Configure(conf *etcd.Node) error {
@@ -42,12 +78,21 @@ func (m *interactivePositions) Configure(conf *etcd.Node) error {
 	if !found {
 		return errors.New("laddr is missing from config")
 	}
-	return nil
+
+	var err error
+	m.access, m.laddr, err = httpAccess.Configure(conf, m.laddr)
+	if err != nil {
+		return err
+	}
+	m.backgroundPath, err = configureView(conf, m.view)
+	return err
 }
 
 func (m *interactivePositions) Initialize(positionManager squirrel.PositionManager) {
 	m.positionManager = positionManager
-	go http.ListenAndServe(m.laddr, m.bindMux())
+	m.sessions = newSessions(positionManager)
+	m.paths = newPaths(m.view.UnitsPerMeter, func(pos *JSPosition) { m.set(pos) })
+	go http.ListenAndServe(m.laddr, m.access.Wrap("InteractivePositions", m.bindMux()))
 }
 
 type JSPosition struct {
@@ -55,24 +100,61 @@ type JSPosition struct {
 	X float64
 	Y float64
 	H float64
+
+	Addr    string `json:",omitempty"` // ignored by /set
+	Enabled bool   // ignored by /set
+}
+
+// addressLookup is implemented by position managers that can tell the
+// hardware address of a node by its index.
+type addressLookup interface {
+	Addr(index int) (string, error)
 }
 
 func positionFromPosition(i int, p *squirrel.Position) *JSPosition {
 	return &JSPosition{I: i, X: p.X, Y: p.Y, H: p.Height}
 }
 
+func (m *interactivePositions) checkIndex(pos *JSPosition) error {
+	if pos == nil {
+		return errors.New("position is null")
+	}
+	if pos.I < 0 || pos.I >= m.positionManager.Capacity() {
+		return fmt.Errorf("node index %d out of range", pos.I)
+	}
+	return nil
+}
+
+func (m *interactivePositions) list() []*JSPosition {
+	lookup, _ := m.positionManager.(addressLookup)
+	ret := make([]*JSPosition, 0)
+	for index := 0; index < m.positionManager.Capacity(); index++ {
+		p, err := m.positionManager.Get(index)
+		if err != nil {
+			continue
+		}
+		pos := positionFromPosition(index, &p)
+		pos.Enabled = m.positionManager.IsEnabled(index)
+		if lookup != nil {
+			pos.Addr, _ = lookup.Addr(index)
+		}
+		ret = append(ret, pos)
+	}
+	return ret
+}
+
+func (m *interactivePositions) set(positions ...*JSPosition) {
+	for _, pos := range positions {
+		m.positionManager.Set(pos.I, pos.X, pos.Y, pos.H)
+	}
+	m.sessions.record(positions...)
+}
+
 func (m *interactivePositions) bindMux() *http.ServeMux {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/list", func(w http.ResponseWriter, req *http.Request) {
-		ret := make([]*JSPosition, 0)
-		for _, index := range m.positionManager.Enabled() {
-			p, err := m.positionManager.Get(index)
-			if err == nil {
-				ret = append(ret, positionFromPosition(index, &p))
-			}
-		}
-		json.NewEncoder(w).Encode(ret)
+		json.NewEncoder(w).Encode(m.list())
 	})
 	mux.HandleFunc("/set", func(w http.ResponseWriter, req *http.Request) {
 		if req.Method != "POST" {
@@ -85,8 +167,47 @@ func (m *interactivePositions) bindMux() *http.ServeMux {
 			http.Error(w, "json Decoding error", 500)
 			return
 		}
-		m.positionManager.Set(pos.I, pos.X, pos.Y, pos.H)
+		if err = m.checkIndex(&pos); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		m.paths.cancel(pos.I)
+		m.set(&pos)
+	})
+	mux.HandleFunc("/setBulk", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			http.NotFound(w, req)
+			return
+		}
+		var positions []*JSPosition
+		err := json.NewDecoder(req.Body).Decode(&positions)
+		if nil != err {
+			http.Error(w, "json Decoding error", 500)
+			return
+		}
+		// validate everything first so that a bad entry doesn't leave the group
+		// half moved
+		for _, pos := range positions {
+			if err = m.checkIndex(pos); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+		}
+		for _, pos := range positions {
+			m.paths.cancel(pos.I)
+		}
+		m.set(positions...)
 	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(m.view)
+	})
+	m.bindSessionHandlers(mux)
+	m.bindPathHandlers(mux)
+	if m.backgroundPath != "" {
+		mux.HandleFunc("/background", func(w http.ResponseWriter, req *http.Request) {
+			http.ServeFile(w, req, m.backgroundPath)
+		})
+	}
 	pkgRoot, err := getRootPath()
 	if err != nil {
 		return nil
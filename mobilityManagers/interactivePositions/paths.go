@@ -0,0 +1,207 @@
+package interactivePositions
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// pathUpdateInterval is how often a node moving along a path gets its
+// position updated.
+const pathUpdateInterval = 100 * time.Millisecond
+
+// JSWaypoint is a point of a path, in position units.
+type JSWaypoint struct {
+	X float64
+	Y float64
+	H float64
+}
+
+// JSPath moves node I along Waypoints at Speed meters per second. Mode is one
+// of "once", "loop" or "pingpong".
+type JSPath struct {
+	I         int
+	Waypoints []JSWaypoint
+	Speed     float64
+	Mode      string
+}
+
+type mover struct {
+	path   *JSPath
+	length float64 // position units
+	stop   chan struct{}
+}
+
+// paths keeps track of nodes that are being moved along a path.
+type paths struct {
+	mu     sync.Mutex
+	active map[int]*mover
+
+	unitsPerMeter float64
+	move          func(*JSPosition)
+}
+
+func newPaths(unitsPerMeter float64, move func(*JSPosition)) *paths {
+	return &paths{active: make(map[int]*mover), unitsPerMeter: unitsPerMeter, move: move}
+}
+
+func (p *paths) start(path *JSPath) error {
+	if len(path.Waypoints) < 2 {
+		return errors.New("a path needs at least 2 waypoints")
+	}
+	if path.Speed <= 0 {
+		return errors.New("speed has to be greater than 0")
+	}
+	switch path.Mode {
+	case "once", "loop", "pingpong":
+	default:
+		return errors.New("mode has to be one of once, loop or pingpong")
+	}
+	length := 0.
+	for i := 1; i < len(path.Waypoints); i++ {
+		length += waypointDistance(&path.Waypoints[i-1], &path.Waypoints[i])
+	}
+	if length == 0 {
+		return errors.New("a path needs at least 2 distinct waypoints")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cancelLocked(path.I)
+	mv := &mover{path: path, length: length, stop: make(chan struct{})}
+	p.active[path.I] = mv
+	go p.run(mv)
+	return nil
+}
+
+func (p *paths) cancel(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cancelLocked(index)
+}
+
+func (p *paths) cancelLocked(index int) {
+	if mv, ok := p.active[index]; ok {
+		close(mv.stop)
+		delete(p.active, index)
+	}
+}
+
+func (p *paths) list() []*JSPath {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ret := make([]*JSPath, 0, len(p.active))
+	for _, mv := range p.active {
+		ret = append(ret, mv.path)
+	}
+	return ret
+}
+
+func (p *paths) run(mv *mover) {
+	path, length := mv.path, mv.length
+	speed := path.Speed * p.unitsPerMeter // units per second
+
+	ticker := time.NewTicker(pathUpdateInterval)
+	defer ticker.Stop()
+	started := time.Now()
+	for {
+		traveled := time.Since(started).Seconds() * speed
+		done := false
+		switch path.Mode {
+		case "once":
+			if traveled >= length {
+				traveled, done = length, true
+			}
+		case "loop":
+			traveled = math.Mod(traveled, length)
+		case "pingpong":
+			traveled = math.Mod(traveled, 2*length)
+			if traveled > length {
+				traveled = 2*length - traveled
+			}
+		}
+
+		select {
+		case <-mv.stop:
+			return
+		default:
+			p.move(pointAlong(path, traveled))
+		}
+
+		if done {
+			p.mu.Lock()
+			if p.active[path.I] == mv {
+				delete(p.active, path.I)
+			}
+			p.mu.Unlock()
+			return
+		}
+
+		select {
+		case <-mv.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func waypointDistance(a, b *JSWaypoint) float64 {
+	return math.Sqrt((a.X-b.X)*(a.X-b.X) + (a.Y-b.Y)*(a.Y-b.Y) + (a.H-b.H)*(a.H-b.H))
+}
+
+// pointAlong returns the position that is traveled units away from the first
+// waypoint of path.
+func pointAlong(path *JSPath, traveled float64) *JSPosition {
+	for i := 1; i < len(path.Waypoints); i++ {
+		a, b := &path.Waypoints[i-1], &path.Waypoints[i]
+		d := waypointDistance(a, b)
+		if traveled <= d && d > 0 {
+			f := traveled / d
+			return &JSPosition{I: path.I, X: a.X + (b.X-a.X)*f, Y: a.Y + (b.Y-a.Y)*f, H: a.H + (b.H-a.H)*f}
+		}
+		traveled -= d
+	}
+	last := path.Waypoints[len(path.Waypoints)-1]
+	return &JSPosition{I: path.I, X: last.X, Y: last.Y, H: last.H}
+}
+
+func (m *interactivePositions) bindPathHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/paths", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(m.paths.list())
+	})
+	mux.HandleFunc("/path", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			http.NotFound(w, req)
+			return
+		}
+		var path JSPath
+		err := json.NewDecoder(req.Body).Decode(&path)
+		if nil != err {
+			http.Error(w, "json Decoding error", 500)
+			return
+		}
+		if err = m.checkIndex(&JSPosition{I: path.I}); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err = m.paths.start(&path); err != nil {
+			http.Error(w, err.Error(), 400)
+		}
+	})
+	mux.HandleFunc("/path/cancel", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			http.NotFound(w, req)
+			return
+		}
+		index, err := strconv.Atoi(req.FormValue("i"))
+		if err != nil {
+			http.Error(w, "invalid i", 400)
+			return
+		}
+		m.paths.cancel(index)
+	})
+}
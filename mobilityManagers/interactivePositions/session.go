@@ -0,0 +1,318 @@
+package interactivePositions
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// JSEvent is one recorded position change. T is the number of milliseconds
+// since the recording started.
+type JSEvent struct {
+	T int64
+	JSPosition
+}
+
+// JSSession is a recorded sequence of position changes. It starts with a
+// snapshot of all enabled nodes at T=0.
+type JSSession struct {
+	Duration int64 // milliseconds
+	Events   []*JSEvent
+}
+
+type JSSessionStatus struct {
+	Recording bool
+	Replaying bool
+	Position  int64 // milliseconds into the session
+	Duration  int64 // milliseconds
+	Speed     float64
+}
+
+type replay struct {
+	stop    chan struct{}
+	started time.Time
+	from    int64
+	speed   float64
+}
+
+// sessions records position changes made through the web UI and replays them
+// onto the position manager.
+type sessions struct {
+	mu sync.Mutex
+	pm squirrel.PositionManager
+
+	current        *JSSession
+	recordingSince time.Time // zero if not recording
+	replay         *replay   // nil if not replaying
+	paused         int64     // where the replay stopped or was seeked to
+}
+
+var errRecording = errors.New("a recording is in progress; stop it first")
+
+func newSessions(pm squirrel.PositionManager) *sessions {
+	return &sessions{pm: pm, current: &JSSession{}}
+}
+
+func (s *sessions) startRecording(snapshot []*JSPosition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopReplayLocked()
+	s.current = &JSSession{}
+	s.paused = 0
+	for _, pos := range snapshot {
+		s.current.Events = append(s.current.Events, &JSEvent{T: 0, JSPosition: *pos})
+	}
+	s.recordingSince = time.Now()
+}
+
+// record appends positions to the session if a recording is in progress.
+func (s *sessions) record(positions ...*JSPosition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recordingSince.IsZero() {
+		return
+	}
+	t := int64(time.Since(s.recordingSince) / time.Millisecond)
+	for _, pos := range positions {
+		s.current.Events = append(s.current.Events, &JSEvent{T: t, JSPosition: *pos})
+	}
+	s.current.Duration = t
+}
+
+// stop stops recording and replaying, whichever is in progress.
+func (s *sessions) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.recordingSince.IsZero() {
+		s.current.Duration = int64(time.Since(s.recordingSince) / time.Millisecond)
+		s.recordingSince = time.Time{}
+	}
+	s.stopReplayLocked()
+}
+
+func (s *sessions) stopReplayLocked() {
+	if s.replay != nil {
+		s.paused = s.replayPositionLocked()
+		close(s.replay.stop)
+		s.replay = nil
+	}
+}
+
+func (s *sessions) session() *JSSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// events are never modified once recorded, so a shallow copy is enough
+	return &JSSession{
+		Duration: s.current.Duration,
+		Events:   append([]*JSEvent(nil), s.current.Events...),
+	}
+}
+
+func (s *sessions) load(session *JSSession) error {
+	for _, ev := range session.Events {
+		if ev == nil || ev.T < 0 || ev.I < 0 || ev.I >= s.pm.Capacity() {
+			return errors.New("invalid event in session")
+		}
+	}
+	sort.Stable(byTime(session.Events))
+	if n := len(session.Events); n > 0 && session.Duration < session.Events[n-1].T {
+		session.Duration = session.Events[n-1].T
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopReplayLocked()
+	s.recordingSince = time.Time{}
+	s.current = session
+	s.paused = 0
+	return nil
+}
+
+// seek moves all nodes to where they were at t milliseconds into the session.
+// A replay in progress continues from t.
+func (s *sessions) seek(t int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.recordingSince.IsZero() {
+		return errRecording
+	}
+	s.applyUntilLocked(t)
+	if s.replay != nil {
+		s.playLocked(t, s.replay.speed)
+	} else {
+		s.paused = t
+	}
+	return nil
+}
+
+// play replays the session from t milliseconds at speed times real time.
+func (s *sessions) play(t int64, speed float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.recordingSince.IsZero() {
+		return errRecording
+	}
+	s.applyUntilLocked(t)
+	s.playLocked(t, speed)
+	return nil
+}
+
+func (s *sessions) applyUntilLocked(t int64) {
+	latest := make(map[int]*JSEvent)
+	for _, ev := range s.current.Events {
+		if ev.T > t {
+			break
+		}
+		latest[ev.I] = ev
+	}
+	for _, ev := range latest {
+		s.pm.Set(ev.I, ev.X, ev.Y, ev.H)
+	}
+}
+
+func (s *sessions) playLocked(from int64, speed float64) {
+	s.stopReplayLocked()
+	r := &replay{stop: make(chan struct{}), started: time.Now(), from: from, speed: speed}
+	s.replay = r
+	go s.run(s.current, r)
+}
+
+func (s *sessions) run(session *JSSession, r *replay) {
+	for _, ev := range session.Events {
+		if ev.T <= r.from {
+			continue
+		}
+		wait := time.Duration(float64(ev.T-r.from)/r.speed*float64(time.Millisecond)) - time.Since(r.started)
+		if wait > 0 {
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+		// r may have been stopped, and the nodes moved by a seek, while
+		// waiting; applying under the lock keeps a stale step from landing
+		// after that.
+		s.mu.Lock()
+		if s.replay != r {
+			s.mu.Unlock()
+			return
+		}
+		s.pm.Set(ev.I, ev.X, ev.Y, ev.H)
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	if s.replay == r {
+		s.replay = nil
+		s.paused = session.Duration
+	}
+	s.mu.Unlock()
+}
+
+func (s *sessions) status() *JSSessionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := &JSSessionStatus{Duration: s.current.Duration, Position: s.paused}
+	if !s.recordingSince.IsZero() {
+		st.Recording = true
+		st.Position = int64(time.Since(s.recordingSince) / time.Millisecond)
+		st.Duration = st.Position
+	} else if s.replay != nil {
+		st.Replaying = true
+		st.Speed = s.replay.speed
+		st.Position = s.replayPositionLocked()
+	}
+	return st
+}
+
+func (s *sessions) replayPositionLocked() int64 {
+	t := s.replay.from + int64(float64(time.Since(s.replay.started)/time.Millisecond)*s.replay.speed)
+	if t > s.current.Duration {
+		t = s.current.Duration
+	}
+	return t
+}
+
+type byTime []*JSEvent
+
+func (e byTime) Len() int           { return len(e) }
+func (e byTime) Less(i, j int) bool { return e[i].T < e[j].T }
+func (e byTime) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+func (m *interactivePositions) bindSessionHandlers(mux *http.ServeMux) {
+	post := func(handler func(w http.ResponseWriter, req *http.Request)) func(w http.ResponseWriter, req *http.Request) {
+		return func(w http.ResponseWriter, req *http.Request) {
+			if req.Method != "POST" {
+				http.NotFound(w, req)
+				return
+			}
+			handler(w, req)
+		}
+	}
+
+	mux.HandleFunc("/session/status", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(m.sessions.status())
+	})
+	mux.HandleFunc("/session/record", post(func(w http.ResponseWriter, req *http.Request) {
+		var snapshot []*JSPosition
+		for _, pos := range m.list() {
+			if pos.Enabled {
+				snapshot = append(snapshot, pos)
+			}
+		}
+		m.sessions.startRecording(snapshot)
+	}))
+	mux.HandleFunc("/session/stop", post(func(w http.ResponseWriter, req *http.Request) {
+		m.sessions.stop()
+	}))
+	mux.HandleFunc("/session/play", post(func(w http.ResponseWriter, req *http.Request) {
+		t, err := strconv.ParseInt(req.FormValue("t"), 10, 64)
+		if err != nil {
+			t = 0
+		}
+		speed := 1.
+		if v := req.FormValue("speed"); v != "" {
+			speed, err = strconv.ParseFloat(v, 64)
+			if err != nil || !(speed > 0) || math.IsInf(speed, 1) {
+				http.Error(w, "invalid speed", 400)
+				return
+			}
+		}
+		if err = m.sessions.play(t, speed); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+	}))
+	mux.HandleFunc("/session/seek", post(func(w http.ResponseWriter, req *http.Request) {
+		t, err := strconv.ParseInt(req.FormValue("t"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid t", 400)
+			return
+		}
+		if err = m.sessions.seek(t); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+	}))
+	mux.HandleFunc("/session/save", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="session.json"`)
+		json.NewEncoder(w).Encode(m.sessions.session())
+	})
+	mux.HandleFunc("/session/load", post(func(w http.ResponseWriter, req *http.Request) {
+		var session JSSession
+		err := json.NewDecoder(req.Body).Decode(&session)
+		if nil != err {
+			http.Error(w, "json Decoding error", 500)
+			return
+		}
+		if err = m.sessions.load(&session); err != nil {
+			http.Error(w, err.Error(), 400)
+		}
+	}))
+}
@@ -0,0 +1,129 @@
+package interactivePositions
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// JSView describes how the world is presented in the web UI. World bounds,
+// grid spacing and background corners are in meters.
+type JSView struct {
+	MinX float64
+	MinY float64
+	MaxX float64
+	MaxY float64
+
+	PixelsPerMeter float64
+	GridSpacing    float64
+	UnitsPerMeter  float64 // squirrel position units in one meter
+
+	Background *JSBackground
+}
+
+// JSBackground is a floor-plan or map image laid under the nodes. The image is
+// stretched so that its corners match the given world coordinates.
+type JSBackground struct {
+	URL    string
+	Left   float64
+	Top    float64
+	Right  float64
+	Bottom float64
+}
+
+func defaultView() *JSView {
+	return &JSView{
+		MinX:           0,
+		MinY:           0,
+		MaxX:           200,
+		MaxY:           140,
+		PixelsPerMeter: 5,
+		GridSpacing:    10,
+		UnitsPerMeter:  1000,
+	}
+}
+
+// configureView reads view related parameters from conf into v. It returns
+// the path of the background image, if any.
+func configureView(conf *etcd.Node, v *JSView) (backgroundPath string, err error) {
+	var bg JSBackground
+	floats := map[string]*float64{
+		"/min_x":             &v.MinX,
+		"/min_y":             &v.MinY,
+		"/max_x":             &v.MaxX,
+		"/max_y":             &v.MaxY,
+		"/pixels_per_meter":  &v.PixelsPerMeter,
+		"/grid_spacing":      &v.GridSpacing,
+		"/units_per_meter":   &v.UnitsPerMeter,
+		"/background_left":   &bg.Left,
+		"/background_top":    &bg.Top,
+		"/background_right":  &bg.Right,
+		"/background_bottom": &bg.Bottom,
+	}
+	corners := 0
+
+	for _, node := range conf.Nodes {
+		if node.Dir {
+			continue
+		}
+		if strings.HasSuffix(node.Key, "/background_image") {
+			backgroundPath = node.Value
+			continue
+		}
+		for suffix, f := range floats {
+			if strings.HasSuffix(node.Key, suffix) {
+				*f, err = strconv.ParseFloat(node.Value, 64)
+				if err != nil {
+					err = fmt.Errorf("parsing %s error: %v", suffix[1:], err)
+					return
+				}
+				if strings.HasPrefix(suffix, "/background_") {
+					corners++
+				}
+				break
+			}
+		}
+	}
+
+	if v.MaxX <= v.MinX || v.MaxY <= v.MinY {
+		err = errors.New("max_x/max_y has to be greater than min_x/min_y")
+		return
+	}
+	if v.PixelsPerMeter <= 0 {
+		err = errors.New("pixels_per_meter has to be greater than 0")
+		return
+	}
+	if v.GridSpacing <= 0 {
+		err = errors.New("grid_spacing has to be greater than 0")
+		return
+	}
+	if v.UnitsPerMeter <= 0 {
+		err = errors.New("units_per_meter has to be greater than 0")
+		return
+	}
+
+	if backgroundPath == "" {
+		if corners != 0 {
+			err = errors.New("background corners are given but background_image is missing")
+		}
+		return
+	}
+	if _, err = os.Stat(backgroundPath); err != nil {
+		return
+	}
+	switch corners {
+	case 0:
+		bg.Left, bg.Top, bg.Right, bg.Bottom = v.MinX, v.MinY, v.MaxX, v.MaxY
+	case 4:
+	default:
+		err = errors.New("background corners have to be given all together or not at all")
+		return
+	}
+	bg.URL = "background"
+	v.Background = &bg
+	return
+}
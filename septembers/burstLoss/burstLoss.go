@@ -0,0 +1,193 @@
+package burstLoss
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/clock"
+	"github.com/squirrel-land/models/septembers/qos"
+	"github.com/squirrel-land/models/septembers/random"
+	"github.com/squirrel-land/models/septembers/wrapper"
+	"github.com/squirrel-land/squirrel"
+)
+
+type burstLoss struct {
+	factory         wrapper.Factory
+	inner           squirrel.September
+	positionManager squirrel.PositionManager
+
+	pGoodToBad float64
+	pBadToGood float64
+	lossGood   float64
+	lossBad    float64
+	random     *random.Source
+
+	mu  sync.Mutex
+	bad map[[2]int]bool // per ordered link; true if in bad state
+}
+
+func CreateSeptember(factory wrapper.Factory) squirrel.September {
+	return &burstLoss{factory: factory, bad: make(map[[2]int]bool)}
+}
+
+func (b *burstLoss) ParametersHelp() string {
+	return `BurstLoss wraps another september and drops packets that it would deliver
+according to a Gilbert-Elliott model: each ordered link is either in a good or
+a bad state, moves between them before each packet with given probabilities,
+and drops packets with a per-state loss rate.
+` + wrapper.InnerParametersHelp + `  "p_good_to_bad":      float64, required;
+                        Probability of going from good to bad state.
+  "p_bad_to_good":      float64, required;
+                        Probability of going from bad to good state.
+  "loss_good":          float64, optional, default 0;
+                        Loss rate in good state.
+  "loss_bad":           float64, optional, default 1;
+                        Loss rate in bad state.` + random.ParametersHelp + `    `
+}
+
+func (b *burstLoss) Configure(conf *etcd.Node) (err error) {
+	if conf == nil {
+		err = errors.New("BurstLoss: conf (*etcd.Node) is nil")
+		return
+	}
+
+	b.pGoodToBad, b.pBadToGood = -1, -1
+	b.lossGood, b.lossBad = 0, 1
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "/p_good_to_bad") {
+			b.pGoodToBad, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/p_bad_to_good") {
+			b.pBadToGood, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/loss_good") {
+			b.lossGood, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/loss_bad") {
+			b.lossBad, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	var errorParameters []string
+	if b.pGoodToBad < 0 || b.pGoodToBad > 1 {
+		errorParameters = append(errorParameters, "p_good_to_bad")
+	}
+	if b.pBadToGood < 0 || b.pBadToGood > 1 {
+		errorParameters = append(errorParameters, "p_bad_to_good")
+	}
+	if b.lossGood < 0 || b.lossGood > 1 {
+		errorParameters = append(errorParameters, "loss_good")
+	}
+	if b.lossBad < 0 || b.lossBad > 1 {
+		errorParameters = append(errorParameters, "loss_bad")
+	}
+	if len(errorParameters) != 0 {
+		err = fmt.Errorf("parameter(s) missing or invalid: %v", errorParameters)
+		return
+	}
+
+	if b.random, err = random.Configure(conf); err != nil {
+		return
+	}
+	b.inner, err = wrapper.ConfigureInner(conf, b.factory)
+	return
+}
+
+func (b *burstLoss) Initialize(positionManager squirrel.PositionManager) {
+	b.positionManager = positionManager
+	b.inner.Initialize(positionManager)
+}
+
+// SetClock passes c on to the inner september. It implements clock.User.
+func (b *burstLoss) SetClock(c clock.Clock) {
+	wrapper.SetClock(b.inner, c)
+}
+
+// lost moves the link from source to destination to its next state and tells
+// whether the packet is lost there. It's called for every packet on the link,
+// whether or not the inner september delivers it, so that the chain doesn't
+// only advance on deliveries.
+func (b *burstLoss) lost(source int, destination int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := [2]int{source, destination}
+	r := b.random.Link("burst", source, destination)
+	bad := b.bad[key]
+	if bad {
+		bad = r.Float64() >= b.pBadToGood
+	} else {
+		bad = r.Float64() < b.pGoodToBad
+	}
+	b.bad[key] = bad
+
+	if bad {
+		return r.Float64() < b.lossBad
+	}
+	return r.Float64() < b.lossGood
+}
+
+// unicast decides on a packet from source to destination, given whether the
+// inner september delivers it.
+func (b *burstLoss) unicast(source int, destination int, delivered bool) bool {
+	lost := b.lost(source, destination)
+	return delivered && !lost
+}
+
+// broadcast drops receivers in delivered, along with their delays, whose
+// links lose the packet.
+func (b *burstLoss) broadcast(source int, delivered []int, delays []time.Duration) ([]int, []time.Duration) {
+	lost := make(map[int]bool)
+	for _, i := range b.positionManager.Enabled() {
+		if i != source && b.lost(source, i) {
+			lost[i] = true
+		}
+	}
+	return wrapper.Filter(delivered, delays, func(i int) bool { return !lost[i] })
+}
+
+func (b *burstLoss) SendUnicast(source int, destination int, size int) bool {
+	return b.unicast(source, destination, b.inner.SendUnicast(source, destination, size))
+}
+
+func (b *burstLoss) SendBroadcast(source int, size int, underlying []int) []int {
+	delivered, _ := b.broadcast(source, b.inner.SendBroadcast(source, size, underlying), nil)
+	return delivered
+}
+
+// SendUnicastWithDelay implements delay.September.
+func (b *burstLoss) SendUnicastWithDelay(source int, destination int, size int) (bool, time.Duration) {
+	delivered, d := wrapper.SendUnicastWithDelay(b.inner, source, destination, size)
+	return b.unicast(source, destination, delivered), d
+}
+
+// SendBroadcastWithDelay implements delay.September.
+func (b *burstLoss) SendBroadcastWithDelay(source int, size int, underlying []int, delays []time.Duration) ([]int, []time.Duration) {
+	delivered, delays := wrapper.SendBroadcastWithDelay(b.inner, source, size, underlying, delays)
+	return b.broadcast(source, delivered, delays)
+}
+
+// SendUnicastWithCategory implements qos.September.
+func (b *burstLoss) SendUnicastWithCategory(source int, destination int, size int, ac qos.AccessCategory) (bool, time.Duration) {
+	delivered, d := wrapper.SendUnicastWithCategory(b.inner, source, destination, size, ac)
+	return b.unicast(source, destination, delivered), d
+}
+
+// SendBroadcastWithCategory implements qos.September.
+func (b *burstLoss) SendBroadcastWithCategory(source int, size int, ac qos.AccessCategory, underlying []int, delays []time.Duration) ([]int, []time.Duration) {
+	delivered, delays := wrapper.SendBroadcastWithCategory(b.inner, source, size, ac, underlying, delays)
+	return b.broadcast(source, delivered, delays)
+}
@@ -0,0 +1,122 @@
+package clock
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// Clock tells what time it is for a september. Septembers that keep state over
+// time, e.g. channel occupancy, use it instead of package time so that they
+// can run faster or slower than real time, or deterministically.
+type Clock interface {
+	Now() time.Time
+}
+
+// User is implemented by septembers whose clock can be replaced, e.g. with a
+// Virtual one driven by a simulator. SetClock is to be called before
+// Initialize.
+type User interface {
+	SetClock(c Clock)
+}
+
+// Real is the wall clock.
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// Scaled runs Speed times as fast as the wall clock, from when it's created.
+type Scaled struct {
+	Speed float64
+
+	origin time.Time
+}
+
+func NewScaled(speed float64) *Scaled {
+	return &Scaled{Speed: speed, origin: time.Now()}
+}
+
+func (s *Scaled) Now() time.Time {
+	elapsed := time.Since(s.origin)
+	return s.origin.Add(time.Duration(float64(elapsed) * s.Speed))
+}
+
+// Virtual only moves when it's told to. It's safe for concurrent use.
+type Virtual struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewVirtual returns a Virtual clock that starts at start.
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start}
+}
+
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+// Advance moves the clock forward by d.
+func (v *Virtual) Advance(d time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.now = v.now.Add(d)
+}
+
+// Set moves the clock to t. Moving it backward is ignored.
+func (v *Virtual) Set(t time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if t.After(v.now) {
+		v.now = t
+	}
+}
+
+const ParametersHelp = `
+  "clock":              string, optional, default "real";
+                        "real" for the wall clock, or "scaled" for one running
+                        clock_speed times as fast. An emulator driving a
+                        simulation can replace it with a virtual clock.
+  "clock_speed":        float64, optional, default 1;
+                        Speed of the "scaled" clock.
+`
+
+// Configure returns the clock described by conf as in ParametersHelp.
+func Configure(conf *etcd.Node) (c Clock, err error) {
+	if conf == nil {
+		return nil, errors.New("clock: conf (*etcd.Node) is nil")
+	}
+
+	kind := "real"
+	speed := 1.
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "/clock") {
+			kind = node.Value
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/clock_speed") {
+			speed, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	if speed <= 0 {
+		return nil, fmt.Errorf("parameter(s) missing or invalid: %v", []string{"clock_speed"})
+	}
+	switch kind {
+	case "real":
+		return Real{}, nil
+	case "scaled":
+		return NewScaled(speed), nil
+	}
+	return nil, errors.New("unknown clock")
+}
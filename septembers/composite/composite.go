@@ -0,0 +1,240 @@
+package composite
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/clock"
+	"github.com/squirrel-land/models/septembers/qos"
+	"github.com/squirrel-land/models/septembers/wrapper"
+	"github.com/squirrel-land/squirrel"
+)
+
+type stage struct {
+	name      string
+	september squirrel.September
+}
+
+type composite struct {
+	factory wrapper.Factory
+	names   func() []string // of septembers factory creates
+	stages  []*stage
+}
+
+func CreateSeptember(factory wrapper.Factory, names func() []string) squirrel.September {
+	return &composite{factory: factory, names: names}
+}
+
+func (c *composite) ParametersHelp() string {
+	help := `Composite chains several septembers. A packet is delivered only if every
+stage delivers it, and broadcast receivers are those that every stage delivers
+to. All stages see every packet, so that stateful ones (e.g. CSMA/CA) keep
+track of the channel even when an earlier stage already dropped the packet.
+Delays of stages add up, and every stage gets the access category of a packet
+if the emulator gives one.
+
+  "stages":             directory, required;
+                        One directory per stage, applied in numeric order of
+                        their keys, and then in string order for keys that
+                        aren't numbers. Each has a "september" key naming the
+                        september, and is passed to it as its config. e.g.
+                        "stages/1/september": "DistanceBased",
+                        "stages/1/transmission_range": "100".
+    `
+	if len(c.stages) != 0 {
+		for _, s := range c.stages {
+			help += "\n" + s.name + ":\n" + s.september.ParametersHelp()
+		}
+		return help
+	}
+
+	// Not configured yet; list every september a stage can be, once for
+	// names of the same september.
+	var helps []string
+	namesOf := make(map[string][]string)
+	for _, name := range c.names() {
+		s, err := c.factory(name)
+		if err != nil {
+			continue
+		}
+		if _, ok := s.(*composite); ok {
+			continue
+		}
+		h := s.ParametersHelp()
+		if _, ok := namesOf[h]; !ok {
+			helps = append(helps, h)
+		}
+		namesOf[h] = append(namesOf[h], name)
+	}
+	for _, h := range helps {
+		help += "\n" + strings.Join(namesOf[h], ", ") + ":\n" + h
+	}
+	return help
+}
+
+func (c *composite) Configure(conf *etcd.Node) (err error) {
+	if conf == nil {
+		err = errors.New("Composite: conf (*etcd.Node) is nil")
+		return
+	}
+
+	var stageConfs etcd.Nodes
+	for _, node := range conf.Nodes {
+		if node.Dir && strings.HasSuffix(node.Key, "/stages") {
+			stageConfs = append(stageConfs, node.Nodes...)
+		}
+	}
+	sort.Sort(byKey(stageConfs))
+
+	for _, stageConf := range stageConfs {
+		if !stageConf.Dir {
+			continue
+		}
+		var name string
+		for _, node := range stageConf.Nodes {
+			if !node.Dir && strings.HasSuffix(node.Key, "/september") {
+				name = node.Value
+			}
+		}
+		if name == "" {
+			return fmt.Errorf("september is missing from %s", stageConf.Key)
+		}
+		s := &stage{name: name}
+		if s.september, err = wrapper.Configure(name, stageConf, c.factory); err != nil {
+			return
+		}
+		c.stages = append(c.stages, s)
+	}
+
+	if len(c.stages) == 0 {
+		return errors.New("stages is missing from config or is empty")
+	}
+	return nil
+}
+
+func (c *composite) Initialize(positionManager squirrel.PositionManager) {
+	for _, s := range c.stages {
+		s.september.Initialize(positionManager)
+	}
+}
+
+// SetClock passes clk on to every stage. It implements clock.User.
+func (c *composite) SetClock(clk clock.Clock) {
+	for _, s := range c.stages {
+		wrapper.SetClock(s.september, clk)
+	}
+}
+
+func (c *composite) SendUnicast(source int, destination int, size int) bool {
+	shouldDeliver, _ := c.unicast(func(s squirrel.September) (bool, time.Duration) {
+		return s.SendUnicast(source, destination, size), 0
+	})
+	return shouldDeliver
+}
+
+func (c *composite) SendBroadcast(source int, size int, underlying []int) []int {
+	delivered, _ := c.broadcast(underlying, nil, func(s squirrel.September, buf []int, _ []time.Duration) ([]int, []time.Duration) {
+		return s.SendBroadcast(source, size, buf), nil
+	})
+	return delivered
+}
+
+// SendUnicastWithDelay implements delay.September.
+func (c *composite) SendUnicastWithDelay(source int, destination int, size int) (bool, time.Duration) {
+	return c.unicast(func(s squirrel.September) (bool, time.Duration) {
+		return wrapper.SendUnicastWithDelay(s, source, destination, size)
+	})
+}
+
+// SendBroadcastWithDelay implements delay.September.
+func (c *composite) SendBroadcastWithDelay(source int, size int, underlying []int, delays []time.Duration) ([]int, []time.Duration) {
+	return c.broadcast(underlying, delays, func(s squirrel.September, buf []int, dbuf []time.Duration) ([]int, []time.Duration) {
+		return wrapper.SendBroadcastWithDelay(s, source, size, buf, dbuf)
+	})
+}
+
+// SendUnicastWithCategory implements qos.September.
+func (c *composite) SendUnicastWithCategory(source int, destination int, size int, ac qos.AccessCategory) (bool, time.Duration) {
+	return c.unicast(func(s squirrel.September) (bool, time.Duration) {
+		return wrapper.SendUnicastWithCategory(s, source, destination, size, ac)
+	})
+}
+
+// SendBroadcastWithCategory implements qos.September.
+func (c *composite) SendBroadcastWithCategory(source int, size int, ac qos.AccessCategory, underlying []int, delays []time.Duration) ([]int, []time.Duration) {
+	return c.broadcast(underlying, delays, func(s squirrel.September, buf []int, dbuf []time.Duration) ([]int, []time.Duration) {
+		return wrapper.SendBroadcastWithCategory(s, source, size, ac, buf, dbuf)
+	})
+}
+
+// unicast sends a packet through every stage with send. It's delivered if
+// every stage delivers it, after the sum of their delays.
+func (c *composite) unicast(send func(s squirrel.September) (bool, time.Duration)) (shouldDeliver bool, d time.Duration) {
+	shouldDeliver = true
+	for _, s := range c.stages {
+		delivered, stageDelay := send(s.september)
+		if !delivered {
+			shouldDeliver = false
+		}
+		d += stageDelay
+	}
+	return
+}
+
+// broadcast sends a packet through every stage with send, which is given
+// buffers as long as underlying. Receivers are those every stage delivers to,
+// with the sum of their delays in delays unless it's nil.
+func (c *composite) broadcast(underlying []int, delays []time.Duration, send func(s squirrel.September, buf []int, dbuf []time.Duration) ([]int, []time.Duration)) ([]int, []time.Duration) {
+	votes := make(map[int]int)
+	total := make(map[int]time.Duration)
+	buf := make([]int, len(underlying))
+	dbuf := make([]time.Duration, len(underlying))
+	for _, s := range c.stages {
+		delivered, stageDelays := send(s.september, buf, dbuf)
+		for k, i := range delivered {
+			votes[i]++
+			if stageDelays != nil {
+				total[i] += stageDelays[k]
+			}
+		}
+	}
+
+	count := 0
+	for i, v := range votes {
+		if v == len(c.stages) {
+			underlying[count] = i
+			count++
+		}
+	}
+	sort.Ints(underlying[:count])
+	if delays == nil {
+		return underlying[:count], nil
+	}
+	for k, i := range underlying[:count] {
+		delays[k] = total[i]
+	}
+	return underlying[:count], delays[:count]
+}
+
+type byKey etcd.Nodes
+
+func (n byKey) Len() int      { return len(n) }
+func (n byKey) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+
+func (n byKey) Less(i, j int) bool {
+	a, errA := strconv.Atoi(n[i].Key[strings.LastIndex(n[i].Key, "/")+1:])
+	b, errB := strconv.Atoi(n[j].Key[strings.LastIndex(n[j].Key, "/")+1:])
+	switch {
+	case errA == nil && errB == nil:
+		return a < b
+	case errA == nil || errB == nil:
+		// numbers first
+		return errA == nil
+	}
+	return n[i].Key < n[j].Key
+}
@@ -0,0 +1,123 @@
+package csmaca
+
+import (
+	"sync"
+	"time"
+)
+
+const ampduParametersHelp = `
+  "ampdu_max_bytes":    int, optional, default 0;
+                        With 802.11n, 802.11ac and 802.11ax, a unicast packet
+                        sent while an earlier frame to the same destination
+                        is still waiting for the medium joins that frame as
+                        an A-MPDU subframe, sharing its DIFS, back-off,
+                        preamble and (block) ACK, as long as the A-MPDU stays
+                        within this many bytes and 64 subframes. Subframes
+                        are received or lost on their own and aren't
+                        retried. 0 turns aggregation off.
+`
+
+// maxSubframes is the block ACK window.
+const maxSubframes = 64
+
+// aggregate is an A-MPDU on a link that later packets may join until it goes
+// on the air.
+type aggregate struct {
+	start     time.Time // of the data frame
+	end       time.Time
+	rate      float64
+	bytes     int
+	subframes int
+	protected bool // by RTS/CTS
+
+	acked bool          // whether the (block) ACK was sent
+	ack   *transmission // the ACK with the sinr reception model
+}
+
+type aggregates struct {
+	mu    sync.Mutex
+	links map[[2]int]*aggregate
+}
+
+// subframeBytes returns length of an A-MPDU subframe carrying a packet of size
+// bytes: MAC header, delimiter and padding to 4 bytes.
+func subframeBytes(size int) int {
+	return (size + 34 + 4 + 3) / 4 * 4
+}
+
+// openAggregate records a data frame carrying a packet of size bytes at rate
+// from source to destination, in the air from start for d, as an A-MPDU that
+// later packets may join. It returns nil if aggregation is off.
+func (c *csmaca) openAggregate(source int, destination int, start time.Time, d time.Duration, size int, rate float64, protected bool) *aggregate {
+	if c.ampduMaxBytes == 0 {
+		return nil
+	}
+	a := &aggregate{start: start, end: start.Add(d), rate: rate, bytes: subframeBytes(size), subframes: 1, protected: protected}
+	c.aggregates.mu.Lock()
+	defer c.aggregates.mu.Unlock()
+	if c.aggregates.links == nil {
+		c.aggregates.links = make(map[[2]int]*aggregate)
+	}
+	c.aggregates.links[[2]int{source, destination}] = a
+	return a
+}
+
+// acked records the (block) ACK of a, tx, being sent.
+func (c *csmaca) acked(a *aggregate, tx *transmission) {
+	if a == nil {
+		return
+	}
+	c.aggregates.mu.Lock()
+	a.acked, a.ack = true, tx
+	c.aggregates.mu.Unlock()
+}
+
+// joinAggregate adds a packet of size bytes from source to destination to the
+// A-MPDU open on the link, if there's one with room left. The subframe
+// lengthens the A-MPDU and pushes its ACK back.
+func (c *csmaca) joinAggregate(source int, destination int, size int) (joined bool, shouldDeliver bool, d time.Duration) {
+	if c.ampduMaxBytes == 0 {
+		return
+	}
+	now := c.clock.Now()
+	bytes := subframeBytes(size)
+
+	c.aggregates.mu.Lock()
+	a := c.aggregates.links[[2]int{source, destination}]
+	if a == nil || !a.start.After(now) || a.bytes+bytes > c.ampduMaxBytes || a.subframes >= maxSubframes {
+		c.aggregates.mu.Unlock()
+		return
+	}
+	durationSubframe := c.durationByBytes(a.bytes+bytes, a.rate) - c.durationByBytes(a.bytes, a.rate)
+	start := a.end
+	a.end = a.end.Add(durationSubframe)
+	a.bytes += bytes
+	a.subframes++
+	rate, aStart, end, protected, acked, ack := a.rate, a.start, a.end, a.protected, a.acked, a.ack
+	c.aggregates.mu.Unlock()
+	joined = true
+
+	if acked {
+		if ack != nil {
+			c.sinr.shift(ack, durationSubframe)
+		}
+		durationAck := c.durationOfAckFrame(rate)
+		c.shiftTransmitted(destination, start.Add(c.phy.sifs), durationAck-c.phy.sifs, durationSubframe)
+	}
+
+	// the subframe Go through source bucket
+	c.buckets[source].In(int64(durationSubframe))
+	tx := c.occupy(source, destination, start, durationSubframe)
+	collided := !protected && c.hidden(source, destination, start, durationSubframe)
+	c.transmitted(source, aStart, end.Sub(aStart))
+
+	// the subframe Go through destination bucket
+	if !c.buckets[destination].In(int64(durationSubframe)) {
+		return
+	}
+	dist := c.radios.Distance(source, destination)
+	if collided || !c.received(tx, source, destination, dist, bytes, rate) {
+		return
+	}
+	return true, true, end.Sub(now) + c.delay.Sample(source, destination)
+}
@@ -0,0 +1,245 @@
+package csmaca
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/radio"
+)
+
+const channelParametersHelp = `
+  "backoff":            string, optional, default "average";
+                        "average" takes half of the contention window as
+                        back-off of every attempt. "random" draws back-off
+                        slots for each attempt, and makes frames collide at
+                        receivers when another node starts transmitting in
+                        the same slot. With random back-off or RTS/CTS, slots
+                        counted down before the medium gets busy are kept,
+                        and the rest are counted down once the medium has
+                        been idle for DIFS (or AIFS) again. This is an
+                        approximation: only the latest busy period of the
+                        medium is known, and every attempt draws its back-off
+                        anew rather than resuming what's left of the last.
+  "rts_threshold":      int, optional, default 0;
+                        Unicast packets larger than this many bytes go
+                        through an RTS/CTS exchange first, which reserves the
+                        medium (NAV) around both source and destination.
+                        Smaller ones are exposed to collisions with hidden
+                        terminals. 0 turns RTS/CTS off; hidden terminals are
+                        then only considered with random back-off.
+`
+
+// maxDeferral is the longest a frame waits for the medium before it's dropped,
+// as if the interface queue were full. It's as much as a bucket holds.
+const maxDeferral = 50 * time.Millisecond
+
+// channelState is what a node knows about the medium. It's only kept with
+// random back-off or RTS/CTS.
+type channelState struct {
+	mu        sync.Mutex
+	busyFrom  time.Time // the node senses the medium busy, or its NAV is set,
+	busyUntil time.Time // from busyFrom until busyUntil
+	txStart   time.Time // start of the node's last frame
+	txEnd     time.Time // end of the node's last frame
+}
+
+// configureBackoff tells whether conf asks for random back-off.
+func configureBackoff(conf *etcd.Node) (random bool, err error) {
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "/backoff") {
+			switch node.Value {
+			case "average":
+				random = false
+			case "random":
+				random = true
+			default:
+				err = errors.New("unknown backoff")
+				return
+			}
+		}
+	}
+	return
+}
+
+// contend returns how long source waits before its frame goes out, with
+// contention window cw: deferred until the medium gets idle, and then ifs
+// (DIFS, or AIFS with EDCA) plus back-off. Time deferred is already accounted
+// for in source's bucket by the frames that kept the medium busy.
+//
+// A busy medium freezes the countdown, which resumes after ifs once the medium
+// is idle again. Nodes don't keep their residual back-off across frames.
+func (c *csmaca) contend(source int, ifs time.Duration, cw int) (deferred time.Duration, backoff time.Duration) {
+	if c.channels == nil {
+		return 0, ifs + c.bo(cw)
+	}
+
+	if c.randomBackoff {
+		slots := c.random.Node("backoff", source).Intn(cw + 1)
+		backoff = ifs + c.phy.slot*time.Duration(slots)
+	} else {
+		backoff = ifs + c.bo(cw)
+	}
+
+	now := c.clock.Now()
+	ch := c.channels[source]
+	ch.mu.Lock()
+	busyFrom, busyUntil := ch.busyFrom, ch.busyUntil
+	ch.mu.Unlock()
+
+	// Frames are decided when they're sent, so the medium may be known to get
+	// busy later on. That only matters if it happens before source would start.
+	if busyUntil.After(now) && busyFrom.Before(now.Add(backoff)) {
+		deferred = busyUntil.Sub(now)
+		// whole slots counted down after ifs and before the medium got busy
+		counted := (busyFrom.Sub(now) - ifs) / c.phy.slot * c.phy.slot
+		if counted > 0 {
+			backoff -= counted
+		}
+	}
+	return
+}
+
+// senses tells whether dest senses a frame from src, i.e. the medium is busy
+// for dest while src transmits. Mean path loss is used so that sensing doesn't
+// draw shadowing or fading.
+func (c *csmaca) senses(src int, dest int) bool {
+	leakage := c.leakage(src, dest)
+	if math.IsInf(leakage, 1) {
+		return false
+	}
+	dist := c.radios.Distance(src, dest)
+	if c.sinr != nil {
+		return c.radios.Of(src).TxPowerDbm-c.sinr.pathLoss.MeanLoss(dist)-leakage >= c.sinr.ccaThreshold
+	}
+	return radio.EquivalentDistance(dist, math.Pow(10, -leakage/10), 3) < c.interferenceRangeOf(src, dest)
+}
+
+// collides tells whether a frame from source starting at start is garbled at
+// dest by another node that started transmitting in the same slot. A dest
+// that is transmitting itself can't receive either.
+func (c *csmaca) collides(source int, dest int, start time.Time) bool {
+	if !c.randomBackoff {
+		return false
+	}
+	for _, i := range c.positionManager.Enabled() {
+		if i == source {
+			continue
+		}
+		ch := c.channels[i]
+		ch.mu.Lock()
+		txStart := ch.txStart
+		ch.mu.Unlock()
+
+		gap := start.Sub(txStart)
+		if gap < 0 {
+			gap = -gap
+		}
+		if gap < c.phy.slot && (i == dest || c.senses(i, dest)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hidden tells whether a frame from source in the air from start for d is
+// garbled at dest by a hidden terminal: a node that can't sense source but
+// whose frame overlaps and is heard by dest.
+func (c *csmaca) hidden(source int, dest int, start time.Time, d time.Duration) bool {
+	if c.channels == nil {
+		return false
+	}
+	end := start.Add(d)
+	for _, i := range c.positionManager.Enabled() {
+		if i == source || i == dest {
+			continue
+		}
+		ch := c.channels[i]
+		ch.mu.Lock()
+		txStart, txEnd := ch.txStart, ch.txEnd
+		ch.mu.Unlock()
+
+		if txStart.Before(end) && txEnd.After(start) && !c.senses(source, i) && c.senses(i, dest) {
+			return true
+		}
+	}
+	return false
+}
+
+// transmitted records a frame from source that is in the air from start for
+// d, so that nodes sensing it defer their back-off.
+func (c *csmaca) transmitted(source int, start time.Time, d time.Duration) {
+	if c.channels == nil {
+		return
+	}
+	end := start.Add(d)
+	ch := c.channels[source]
+	ch.mu.Lock()
+	ch.txStart, ch.txEnd = start, end
+	ch.mu.Unlock()
+	c.reserve(source, start, end)
+}
+
+// reserve keeps source and nodes that sense it off the medium from start until
+// end, as the medium being busy or a NAV set by RTS/CTS would.
+func (c *csmaca) reserve(source int, start time.Time, end time.Time) {
+	if c.channels == nil {
+		return
+	}
+	for _, i := range c.positionManager.Enabled() {
+		if i != source && !c.senses(source, i) {
+			continue
+		}
+		ch := c.channels[i]
+		ch.mu.Lock()
+		ch.busy(start, end)
+		ch.mu.Unlock()
+	}
+}
+
+// busy adds start to end to the time ch senses the medium busy. ch.mu must be
+// held.
+func (ch *channelState) busy(start time.Time, end time.Time) {
+	if ch.busyUntil.Before(start) || start.Before(ch.busyFrom) {
+		ch.busyFrom = start
+	}
+	if ch.busyUntil.Before(end) {
+		ch.busyUntil = end
+	}
+}
+
+// shiftTransmitted moves a frame that transmitted recorded from source, in the
+// air from start for d, by later, as when the A-MPDU it answers grows. Nodes
+// that sensed nothing else after it stop sensing it at its old time.
+func (c *csmaca) shiftTransmitted(source int, start time.Time, d time.Duration, by time.Duration) {
+	if c.channels == nil {
+		return
+	}
+	end := start.Add(d)
+	newStart, newEnd := start.Add(by), end.Add(by)
+	ch := c.channels[source]
+	ch.mu.Lock()
+	if ch.txStart.Equal(start) {
+		ch.txStart, ch.txEnd = newStart, newEnd
+	}
+	ch.mu.Unlock()
+	for _, i := range c.positionManager.Enabled() {
+		if i != source && !c.senses(source, i) {
+			continue
+		}
+		ch := c.channels[i]
+		ch.mu.Lock()
+		if ch.busyUntil.Equal(end) && !ch.busyFrom.Before(start) {
+			// the frame is all that i senses busy
+			ch.busyFrom, ch.busyUntil = newStart, newEnd
+		} else if ch.busyUntil.Equal(end) {
+			ch.busyUntil = newEnd
+		} else {
+			ch.busy(newStart, newEnd)
+		}
+		ch.mu.Unlock()
+	}
+}
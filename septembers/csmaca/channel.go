@@ -0,0 +1,218 @@
+package csmaca
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/httpAccess"
+	"github.com/squirrel-land/models/septembers/nodeRef"
+	"github.com/squirrel-land/models/septembers/radio"
+)
+
+const tuningParametersHelp = `
+  "channel":            int, optional, default 1;
+                        Channel nodes are on unless channels says otherwise.
+                        Channels are positive numbers. Nodes on different
+                        channels neither receive nor sense each other, and
+                        only interfere through adjacent_channel_rejection_db.
+  "channels":           directory, optional;
+                        Channel of nodes by node index or hardware address,
+                        e.g. "channels/3": "6".
+  "adjacent_channel_rejection_db":
+                        float64, optional;
+                        Frames on a channel n numbers away are n times this
+                        many dB weaker; the bucket model shrinks distance
+                        accordingly, as if received power fell with d^3.
+                        Without it, other channels don't leak at all.
+  "channel_laddr":      string, optional;
+                        TCP address to serve an HTTP API on: GET /channels
+                        lists channels of nodes by index; POST /channels with
+                        a JSON object of channels by node index or hardware
+                        address retunes them, e.g. {"3": 11}, or none of them
+                        if any node or channel is invalid. If the host part
+                        is empty, only localhost is listened on unless
+                        "public" is true.` + httpAccess.ParametersHelp
+
+// Tunable is implemented by the CSMA/CA september so that channel switching
+// protocols can retune nodes at runtime.
+type Tunable interface {
+	// Channel returns the channel node is on, or 0 if there's no such node.
+	Channel(node int) int
+	// SetChannel moves node to channel, which has to be positive.
+	SetChannel(node int, channel int) error
+}
+
+type tuning struct {
+	channel   int
+	byRef     map[string]int // from config, by nodeRef
+	rejection float64        // dB per channel number; NaN if other channels don't leak
+	laddr     string
+	access    *httpAccess.Access
+
+	mu    sync.RWMutex
+	tuned []int // by node index
+}
+
+func configureTuning(conf *etcd.Node) (t *tuning, err error) {
+	t = &tuning{channel: 1, byRef: make(map[string]int), rejection: math.NaN()}
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "/channel") {
+			if t.channel, err = strconv.Atoi(node.Value); err != nil {
+				return
+			}
+		} else if node.Dir && strings.HasSuffix(node.Key, "/channels") {
+			for _, n := range node.Nodes {
+				var channel int
+				if channel, err = strconv.Atoi(n.Value); err != nil {
+					return
+				}
+				t.byRef[nodeRef.Normalize(n.Key[strings.LastIndex(n.Key, "/")+1:])] = channel
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/adjacent_channel_rejection_db") {
+			if t.rejection, err = strconv.ParseFloat(node.Value, 64); err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/channel_laddr") {
+			t.laddr = node.Value
+		}
+	}
+	if t.laddr != "" {
+		if t.access, t.laddr, err = httpAccess.Configure(conf, t.laddr); err != nil {
+			return
+		}
+	}
+	if t.channel <= 0 {
+		return nil, errors.New("channel has to be positive")
+	}
+	for ref, channel := range t.byRef {
+		if channel <= 0 {
+			return nil, fmt.Errorf("channels/%s: channel has to be positive", ref)
+		}
+	}
+	return
+}
+
+// initializeTuning puts every node on its configured channel.
+func (c *csmaca) initializeTuning() {
+	t := c.tuning
+	for ref := range t.byRef {
+		if err := nodeRef.Check(c.positionManager, ref); err != nil {
+			log.Fatalf("initializing CSMA/CA error: channels: %s", err.Error())
+		}
+	}
+	t.tuned = make([]int, c.positionManager.Capacity())
+	for i := range t.tuned {
+		t.tuned[i] = t.channel
+		for _, ref := range nodeRef.Of(c.positionManager, i) {
+			if channel, ok := t.byRef[ref]; ok {
+				t.tuned[i] = channel
+			}
+		}
+	}
+	if t.laddr != "" {
+		if err := t.access.Serve(t.laddr, "CSMA/CA channels", c.bindTuningMux()); err != nil {
+			log.Fatalf("initializing CSMA/CA error: channel_laddr: %s", err.Error())
+		}
+	}
+}
+
+// Channel returns the channel node is on, or 0 if there's no such node. It
+// implements Tunable.
+func (c *csmaca) Channel(node int) int {
+	c.tuning.mu.RLock()
+	defer c.tuning.mu.RUnlock()
+	if node < 0 || node >= len(c.tuning.tuned) {
+		return 0
+	}
+	return c.tuning.tuned[node]
+}
+
+// SetChannel moves node to channel. It implements Tunable.
+func (c *csmaca) SetChannel(node int, channel int) error {
+	if channel <= 0 {
+		return fmt.Errorf("invalid channel %d", channel)
+	}
+	c.tuning.mu.Lock()
+	defer c.tuning.mu.Unlock()
+	if node < 0 || node >= len(c.tuning.tuned) {
+		return fmt.Errorf("no node %d", node)
+	}
+	c.tuning.tuned[node] = channel
+	return nil
+}
+
+// leakage returns how many dB weaker a frame from src is at dest for being on
+// another channel; +Inf if it doesn't get there at all.
+func (c *csmaca) leakage(src int, dest int) float64 {
+	a, b := c.Channel(src), c.Channel(dest)
+	switch {
+	case a == b:
+		return 0
+	case math.IsNaN(c.tuning.rejection):
+		return math.Inf(1)
+	}
+	return math.Abs(float64(a-b)) * c.tuning.rejection
+}
+
+// interferes tells whether a frame from src keeps i busy in the bucket model.
+func (c *csmaca) interferes(src int, i int) bool {
+	leakage := c.leakage(src, i)
+	if math.IsInf(leakage, 1) {
+		return false
+	}
+	dist := radio.EquivalentDistance(c.radios.Distance(src, i), math.Pow(10, -leakage/10), 3)
+	return c.random.Link("interference", src, i).Float64() < 1-math.Pow(dist/c.interferenceRangeOf(src, i), 6)
+}
+
+func (c *csmaca) bindTuningMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/channels", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" {
+			var channels map[string]int
+			if err := json.NewDecoder(req.Body).Decode(&channels); err != nil {
+				http.Error(w, "json Decoding error", 500)
+				return
+			}
+			byRef := make(map[string]int)
+			for ref, channel := range channels {
+				ref = nodeRef.Normalize(ref)
+				if err := nodeRef.Check(c.positionManager, ref); err != nil {
+					http.Error(w, err.Error(), 400)
+					return
+				}
+				if channel <= 0 {
+					http.Error(w, fmt.Sprintf("invalid channel %d", channel), 400)
+					return
+				}
+				byRef[ref] = channel
+			}
+			// all nodes are known by now; retune them at once
+			c.tuning.mu.Lock()
+			defer c.tuning.mu.Unlock()
+			for i := range c.tuning.tuned {
+				for _, ref := range nodeRef.Of(c.positionManager, i) {
+					if channel, ok := byRef[ref]; ok {
+						c.tuning.tuned[i] = channel
+					}
+				}
+			}
+			return
+		}
+
+		c.tuning.mu.RLock()
+		tuned := append([]int(nil), c.tuning.tuned...)
+		c.tuning.mu.RUnlock()
+		json.NewEncoder(w).Encode(tuned)
+	})
+
+	return mux
+}
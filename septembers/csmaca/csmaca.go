@@ -3,13 +3,18 @@ package csmaca
 import (
 	"errors"
 	"fmt"
+	"log"
 	"math"
-	"math/rand"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/clock"
+	"github.com/squirrel-land/models/septembers/delay"
+	"github.com/squirrel-land/models/septembers/qos"
+	"github.com/squirrel-land/models/septembers/radio"
+	"github.com/squirrel-land/models/septembers/random"
 	"github.com/squirrel-land/squirrel"
 )
 
@@ -18,13 +23,34 @@ type csmaca struct {
 	interferenceRange float64
 
 	positionManager squirrel.PositionManager
+	clock           clock.Clock
 	buckets         []*leakyBucket // measured by number of nanoseconds used;
-	dataRate        float64        // bit per nanosecond
+	dataRateMbps    float64
+	rate            rateControl
 
 	difs time.Duration // nanoseconds
 	phy  *phy
 
 	ucastMaxTXAttempts int // max # of transmissions for each frame
+
+	randomBackoff bool
+	rtsThreshold  int             // bytes; 0 means RTS/CTS is not used
+	ampduMaxBytes int             // bytes; 0 means frames aren't aggregated
+	channels      []*channelState // nil unless backoff is random or RTS/CTS is used
+
+	edca       []edcaParameters // by qos.AccessCategory; nil unless edca is on
+	txops      []*txopState     // nil unless edca is on
+	classifier qos.Classifier
+
+	tuning *tuning // channels of nodes
+	radios radio.PerNode
+
+	aggregates aggregates // open A-MPDUs; only used with ampdu_max_bytes
+
+	fading radio.Fading
+	sinr   *sinrModel // nil unless reception_model is sinr
+	delay  delay.Model
+	random *random.Source
 }
 
 func CreateSeptember() squirrel.September {
@@ -46,14 +72,42 @@ between nodes and interference, etc..
                         than 2x transmission range.
   "mac_protocol"      : string, required;
                         The MAC layer protocol to use. Has to be one of:
-                        802.11a, 802.11g, 802.11p10MHz, 802.11p20MHz.
+                        802.11a, 802.11b, 802.11bShortPreamble, 802.11g,
+                        802.11p5MHz, 802.11p10MHz, 802.11p20MHz, 802.11n,
+                        802.11ac, 802.11ax. The short preamble of 802.11b
+                        can't carry 1 Mbps. Rates of 802.11n (HT), 802.11ac
+                        (VHT) and 802.11ax (HE) follow from their MCSs and
+                        the three parameters below.
+  "channel_width_mhz":  int, optional, default 20;
+                        20 or 40 with 802.11n; 20, 40, 80 or 160 with
+                        802.11ac and 802.11ax. noise_floor_dbm of the sinr
+                        reception model grows with it. This and the next two
+                        are errors with other mac_protocols.
+  "guard_interval_ns":  int, optional, default 800;
+                        800 or 400 with 802.11n and 802.11ac; 800, 1600 or
+                        3200 with 802.11ax.
+  "spatial_streams":    int, optional, default 1;
+                        Up to 4 with 802.11n, up to 8 otherwise.
   "max_ucast_attempts": int, required;
                         The maximum number of transmissions that a STA can
                         attempt for the same fame. This is for MAC layer
                         unicast retransmissions.
 	"data_rate_mbps":     float64, required;
 												MAC data rate in Mbps.
-    `
+
+Optional fading scales the distance as if received power fell with d^3, or
+adds to received power with the sinr reception model.` +
+		rateParametersHelp + channelParametersHelp + ampduParametersHelp + edcaParametersHelp + tuningParametersHelp + radio.PerNodeParametersHelp + random.ParametersHelp + clock.ParametersHelp + radio.FadingParametersHelp + sinrParametersHelp + `
+Airtime of a frame is its PLCP preamble and header plus whole OFDM symbols of
+SERVICE, data and tail bits, as in 802.11; 802.11b rounds up to microseconds.
+ACK, RTS and CTS frames go at the highest basic rate not above the rate of the
+frame they answer or announce: 6, 12 or 24 Mbps with 20 MHz OFDM (halved with
+10 MHz and quartered with 5 MHz), and 1 or 2 Mbps with 802.11b. 802.11n,
+802.11ac and 802.11ax send them as 20 MHz OFDM.
+
+Delivery delay of a packet is the time spent in DIFS, back-off, data frames
+and ACK timeouts until the frame is received, plus the delay below.` +
+		delay.ParametersHelp
 }
 
 func (c *csmaca) Configure(conf *etcd.Node) (err error) {
@@ -62,6 +116,13 @@ func (c *csmaca) Configure(conf *etcd.Node) (err error) {
 		return
 	}
 
+	// HT, VHT and HE phys are built from channel width, guard interval and
+	// spatial streams once they're all known.
+	var m *mimo
+	width, gi, streams := 20, time.Duration(0), 1
+	mimoSet := false // any of the three given, which other phys reject
+	ocb := false     // 802.11p outside the context of a BSS
+
 	for _, node := range conf.Nodes {
 		if !node.Dir && strings.HasSuffix(node.Key, "transmission_range") {
 			c.transmissionRange, err = strconv.ParseFloat(node.Value, 64)
@@ -77,35 +138,104 @@ func (c *csmaca) Configure(conf *etcd.Node) (err error) {
 			switch node.Value {
 			case "802.11a":
 				c.phy = phy80211a
+			case "802.11b":
+				c.phy = phyDSSSLong
+			case "802.11bShortPreamble":
+				c.phy = phyDSSSShort
 			case "802.11g":
 				c.phy = phy80211g
+			case "802.11p5MHz":
+				c.phy, ocb = phy80211p5, true
 			case "802.11p10MHz":
-				c.phy = phy80211p10
+				c.phy, ocb = phy80211p10, true
 			case "802.11p20MHz":
-				c.phy = phy80211p20
+				c.phy, ocb = phy80211p20, true
+			case "802.11n":
+				m = mimoHT
+			case "802.11ac":
+				m = mimoVHT
+			case "802.11ax":
+				m = mimoHE
 			default:
 				err = errors.New("unknown mac_protocol")
 				return
 			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "channel_width_mhz") {
+			width, err = strconv.Atoi(node.Value)
+			if err != nil {
+				return
+			}
+			mimoSet = true
+		} else if !node.Dir && strings.HasSuffix(node.Key, "guard_interval_ns") {
+			var ns int
+			ns, err = strconv.Atoi(node.Value)
+			if err != nil {
+				return
+			}
+			gi = time.Duration(ns) * time.Nanosecond
+			mimoSet = true
+		} else if !node.Dir && strings.HasSuffix(node.Key, "spatial_streams") {
+			streams, err = strconv.Atoi(node.Value)
+			if err != nil {
+				return
+			}
+			mimoSet = true
+		} else if !node.Dir && strings.HasSuffix(node.Key, "ampdu_max_bytes") {
+			c.ampduMaxBytes, err = strconv.Atoi(node.Value)
+			if err != nil {
+				return
+			}
 		} else if !node.Dir && strings.HasSuffix(node.Key, "max_ucast_attempts") {
 			c.ucastMaxTXAttempts, err = strconv.Atoi(node.Value)
 			if err != nil {
 				return
 			}
 		} else if !node.Dir && strings.HasSuffix(node.Key, "data_rate_mbps") {
-			c.dataRate, err = strconv.ParseFloat(node.Value, 64)
+			c.dataRateMbps, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "rts_threshold") {
+			c.rtsThreshold, err = strconv.Atoi(node.Value)
 			if err != nil {
 				return
 			}
-			c.dataRate = c.dataRate * 1024 * 1024 * 1e-9
 		}
 	}
 
+	if m != nil {
+		if c.phy, err = newMIMOPHY(m, width, gi, streams); err != nil {
+			return
+		}
+	} else if mimoSet {
+		return errors.New("channel_width_mhz, guard_interval_ns and spatial_streams only apply to 802.11n, 802.11ac and 802.11ax")
+	}
+
+	if c.sinr, err = configureSINR(conf); err != nil {
+		return
+	}
+	if c.tuning, err = configureTuning(conf); err != nil {
+		return
+	}
+	if err = c.radios.Configure(conf); err != nil {
+		return
+	}
+	if c.sinr != nil && c.radios.SetsTransmissionRange() {
+		return errors.New("TransmissionRange of radio_classes and radio_nodes doesn't apply to the sinr reception_model; use TxPowerDbm and SensitivityDbm")
+	}
+	if c.sinr != nil && m != nil {
+		// noise_floor_dbm is of a 20 MHz channel
+		c.sinr.noise *= float64(width) / 20
+	}
+	if c.randomBackoff, err = configureBackoff(conf); err != nil {
+		return
+	}
+
 	var errorParameters []string
-	if c.transmissionRange <= 0 {
+	if c.sinr == nil && c.transmissionRange <= 0 {
 		errorParameters = append(errorParameters, "transmission_range")
 	}
-	if c.interferenceRange <= 0 {
+	if c.sinr == nil && c.interferenceRange <= 0 {
 		errorParameters = append(errorParameters, "interference_range")
 	}
 	if c.phy == nil {
@@ -114,26 +244,109 @@ func (c *csmaca) Configure(conf *etcd.Node) (err error) {
 	if c.ucastMaxTXAttempts <= 0 {
 		errorParameters = append(errorParameters, "max_ucast_attempts")
 	}
-	if c.dataRate <= 0 {
+	if c.dataRateMbps <= 0 || c.phy != nil && !c.phy.supports(c.dataRateMbps) {
 		errorParameters = append(errorParameters, "data_rate_mbps")
 	}
+	if c.rtsThreshold < 0 {
+		errorParameters = append(errorParameters, "rts_threshold")
+	}
+	if c.ampduMaxBytes < 0 || c.phy != nil && c.ampduMaxBytes > c.phy.maxAMPDU {
+		errorParameters = append(errorParameters, "ampdu_max_bytes")
+	}
 
 	if len(errorParameters) != 0 {
 		err = fmt.Errorf("parameter(s) missing or invalid: %v", errorParameters)
 		return
 	}
 
+	if c.random, err = random.Configure(conf); err != nil {
+		return
+	}
+	if c.edca, err = configureEDCA(conf, c.phy, ocb); err != nil {
+		return
+	}
+	if err = c.classifier.Configure(conf); err != nil {
+		return
+	}
+	if c.rate, err = configureRate(conf, c.dataRateMbps, c.phy.rates, c.qualifies, c.random); err != nil {
+		return
+	}
+	if table, ok := c.rate.(*tableRate); ok {
+		for _, e := range table.entries {
+			if !c.phy.supports(e.rate) {
+				return fmt.Errorf("rate_table: %v Mbps is too low for mac_protocol", e.rate)
+			}
+		}
+	}
+
+	if c.sinr != nil {
+		c.sinr.baseRate = c.dataRateMbps
+		rates := []float64{c.dataRateMbps}
+		if _, ok := c.rate.(fixedRate); !ok {
+			rates = append(rates, c.phy.rates...)
+		}
+		if table, ok := c.rate.(*tableRate); ok {
+			for _, e := range table.entries {
+				rates = append(rates, e.rate)
+			}
+		}
+		rates = append(rates, c.phy.controlPHY().basic...)
+		for _, rate := range rates {
+			if err = c.sinr.check(rate); err != nil {
+				return
+			}
+		}
+	}
+
 	c.difs = c.phy.sifs + 2*c.phy.slot
-	return
+	if c.clock == nil {
+		if c.clock, err = clock.Configure(conf); err != nil {
+			return
+		}
+	}
+	if err = c.fading.Configure(conf); err != nil {
+		return
+	}
+	return c.delay.Configure(conf)
 }
 
 func (c *csmaca) Initialize(positionManager squirrel.PositionManager) {
 	c.positionManager = positionManager
+	c.fading.SetClock(c.clock)
+	c.fading.Initialize(positionManager)
+	c.initializeTuning()
+	defaults := radio.Radio{TransmissionRange: c.transmissionRange}
+	if c.sinr != nil {
+		defaults.TxPowerDbm, defaults.SensitivityDbm = c.sinr.txPower, math.Inf(-1)
+	}
+	if err := c.radios.Initialize(positionManager, defaults); err != nil {
+		log.Fatalf("initializing CSMA/CA error: %s", err.Error())
+	}
+	if c.sinr != nil {
+		c.sinr.clock = c.clock
+	}
 	c.buckets = make([]*leakyBucket, positionManager.Capacity())
 	for it := range c.buckets {
-		c.buckets[it] = NewLeakyBucket(50*1000*1000, time.Millisecond, 1000*1000)
-		c.buckets[it].Go()
+		c.buckets[it] = NewLeakyBucket(50*1000*1000, time.Millisecond, 1000*1000, c.clock)
 	}
+	if c.randomBackoff || c.rtsThreshold > 0 {
+		c.channels = make([]*channelState, positionManager.Capacity())
+		for it := range c.channels {
+			c.channels[it] = new(channelState)
+		}
+	}
+	if c.edca != nil {
+		c.txops = make([]*txopState, positionManager.Capacity())
+		for it := range c.txops {
+			c.txops[it] = new(txopState)
+		}
+	}
+}
+
+// SetClock replaces the clock configured by "clock". It implements
+// clock.User.
+func (c *csmaca) SetClock(clk clock.Clock) {
+	c.clock = clk
 }
 
 func (c *csmaca) bo(cw int) time.Duration { // back-off time
@@ -141,55 +354,227 @@ func (c *csmaca) bo(cw int) time.Duration { // back-off time
 	return c.phy.slot * time.Duration(cw) / 2
 }
 
-func (c *csmaca) durationByBytes(bytes int) time.Duration {
-	return time.Duration(float64(bytes*8)/c.dataRate) * time.Nanosecond
+// durationByBytes returns airtime of a frame of bytes at rate Mbps, PLCP
+// preamble and header included.
+func (c *csmaca) durationByBytes(bytes int, rate float64) time.Duration {
+	return c.phy.airtime(bytes, rate)
 }
 
-func (c *csmaca) durationOfDataFrame(payloadSize int) time.Duration {
+func (c *csmaca) durationOfDataFrame(payloadSize int, rate float64) time.Duration {
 	frameBytes := payloadSize + 34 // MAC data frame header is 34 bytes
-	return c.durationByBytes(frameBytes)
+	return c.durationByBytes(frameBytes, rate)
+}
+
+// controlRate returns the rate of a control frame answering, or announcing, a
+// frame at rate Mbps.
+func (c *csmaca) controlRate(rate float64) float64 {
+	return c.phy.controlPHY().basicRate(rate)
+}
+
+// durationOfAckFrame returns SIFS plus airtime of an ACK to a frame at rate
+// Mbps.
+func (c *csmaca) durationOfAckFrame(rate float64) time.Duration {
+	return c.phy.sifs + c.phy.controlPHY().airtime(14, c.controlRate(rate)) // ACK is 14 bytes
 }
 
-func (c *csmaca) durationOfAckFrame() time.Duration {
-	return c.phy.sifs + c.durationByBytes(14) // ACK is 14 bytes
+// durationOfRTSFrame returns airtime of an RTS announcing a frame at rate Mbps.
+func (c *csmaca) durationOfRTSFrame(rate float64) time.Duration {
+	return c.phy.controlPHY().airtime(20, c.controlRate(rate)) // RTS is 20 bytes
 }
 
-func (c *csmaca) deliverRate(dest int, dist float64) float64 {
-	usage := c.buckets[dest].Usage()
+// durationOfCTSFrame returns SIFS plus airtime of a CTS to an RTS announcing a
+// frame at rate Mbps.
+func (c *csmaca) durationOfCTSFrame(rate float64) time.Duration {
+	return c.phy.sifs + c.phy.controlPHY().airtime(14, c.controlRate(rate)) // CTS is 14 bytes
+}
+
+// deliverRate returns the probability that a frame from src at rate Mbps gets
+// through to dest, dist away, given how busy the bucket of node busy is.
+func (c *csmaca) deliverRate(src int, dest int, busy int, dist float64, rate float64) float64 {
+	if c.Channel(src) != c.Channel(dest) {
+		return 0
+	}
+	dist = radio.EquivalentDistance(dist, c.fading.Gain(src, dest), 3)
+	usage := c.buckets[busy].Usage()
 	p_rate := (1-usage)*.1 + .9 // usage transformed from [0, 1] to [.9, 1]
-	return p_rate * (1 - math.Pow(dist/c.transmissionRange, 3))
+	return p_rate * (1 - math.Pow(dist/c.rangeAt(src, dest, rate), 3))
+}
+
+// interferenceRangeOf returns interference range of the bucket model from src
+// to dest, which stretches along with the link's transmission range.
+func (c *csmaca) interferenceRangeOf(src int, dest int) float64 {
+	return c.interferenceRange * c.radios.LinkRange(src, dest, 3) / c.transmissionRange
 }
 
-func (c *csmaca) SendUnicast(source int, destination int, size int) (shouldDeliver bool) {
+// rxPower returns received power in dBm at dest of a frame from src, less
+// leakage if they're on different channels. Only used by the sinr reception
+// model.
+func (c *csmaca) rxPower(src int, dest int) float64 {
+	dist := c.radios.Distance(src, dest)
+	return c.radios.Of(src).TxPowerDbm - c.sinr.pathLoss.Loss(dist, c.random.Link("shadowing", src, dest)) + c.fading.GainDB(src, dest) - c.leakage(src, dest)
+}
+
+// occupy puts a frame from src to dest that lasts d from start into the air,
+// and puts interference on nodes other than src and dest. The returned
+// transmission is nil unless the sinr reception model is used.
+func (c *csmaca) occupy(src int, dest int, start time.Time, d time.Duration) *transmission {
+	var tx *transmission
+	if c.sinr != nil {
+		tx = c.sinr.transmit(src, start, d)
+	}
+	for _, i := range c.positionManager.Enabled() {
+		if i == src || i == dest {
+			continue
+		}
+		if c.sinr != nil {
+			if c.rxPower(src, i) >= c.sinr.ccaThreshold {
+				c.buckets[i].In(int64(d))
+			}
+		} else if c.interferes(src, i) {
+			c.buckets[i].In(int64(d))
+		}
+	}
+	return tx
+}
+
+// received decides whether a frame of bytes at rate Mbps from src makes it to
+// dest, dist away. tx is what occupy() returned for the frame.
+func (c *csmaca) received(tx *transmission, src int, dest int, dist float64, bytes int, rate float64) bool {
+	if c.sinr == nil {
+		return c.random.Link("rx", src, dest).Float64() <= c.deliverRate(src, dest, dest, dist, rate)
+	}
+	if c.Channel(src) != c.Channel(dest) {
+		// leakage may be heard, but not decoded
+		return false
+	}
+
+	interference := c.sinr.noise
+	for _, i := range c.sinr.interferers(tx) {
+		if i == dest {
+			// half-duplex; dest can't hear while transmitting
+			return false
+		}
+		interference += dBm2mW(c.rxPower(i, dest))
+	}
+	signal := c.rxPower(src, dest)
+	if signal < c.radios.Of(dest).SensitivityDbm {
+		return false
+	}
+	sinr := signal - mW2dBm(interference)
+	return c.random.Link("rx", src, dest).Float64() >= c.sinr.perAt(sinr, rate, bytes)
+}
+
+func (c *csmaca) SendUnicast(source int, destination int, size int) bool {
+	shouldDeliver, _ := c.SendUnicastWithDelay(source, destination, size)
+	return shouldDeliver
+}
+
+func (c *csmaca) SendUnicastWithDelay(source int, destination int, size int) (bool, time.Duration) {
+	return c.sendUnicast(source, destination, size, c.classifier.Classify(size))
+}
+
+// SendUnicastWithCategory implements qos.September.
+func (c *csmaca) SendUnicastWithCategory(source int, destination int, size int, ac qos.AccessCategory) (bool, time.Duration) {
+	if !ac.Valid() {
+		ac = qos.BestEffort
+	}
+	return c.sendUnicast(source, destination, size, ac)
+}
+
+func (c *csmaca) sendUnicast(source int, destination int, size int, ac qos.AccessCategory) (shouldDeliver bool, d time.Duration) {
 	if !(c.positionManager.IsEnabled(source) && c.positionManager.IsEnabled(destination)) {
 		return
 	}
+	if joined, ok, wait := c.joinAggregate(source, destination, size); joined {
+		return ok, wait
+	}
 
-	durationFrame := c.durationOfDataFrame(size)
-	durationAck := c.durationOfAckFrame()
+	// rate, durationFrame, durationAck and durationHandshake (RTS, SIFS, CTS
+	// and SIFS before data) are picked for each attempt
+	var rate float64
+	var durationFrame, durationAck, durationHandshake time.Duration
+
+	useRTS := c.rtsThreshold > 0 && size > c.rtsThreshold
+	dist := c.radios.Distance(source, destination)
+
+	// response tells whether a CTS or ACK frame of bytes at rate Mbps, tx,
+	// gets from destination back to source.
+	response := func(tx *transmission, bytes int, rate float64) bool {
+		if c.sinr == nil {
+			// the bucket model looks at destination's bucket for ACKs as well,
+			// but at the range of the link back to source
+			return c.random.Link("rx", destination, source).Float64() <= c.deliverRate(destination, source, destination, dist, rate)
+		}
+		return c.received(tx, destination, source, dist, bytes, rate)
+	}
 
-	usend := func(cw int) (data bool, ack bool) {
+	usend := func(deferred time.Duration, backoff time.Duration) (data bool, ack bool) {
+		start := c.clock.Now().Add(deferred + backoff)
 
-		// Go through source bucket
-		if !c.buckets[source].In(int64(c.difs + c.bo(cw) + durationFrame)) {
-			return
-		}
+		if useRTS {
+			durationRTS, durationCTS := c.durationOfRTSFrame(rate), c.durationOfCTSFrame(rate)
+			// NAV set by RTS and CTS lasts until the end of the ACK.
+			nav := start.Add(durationHandshake + durationFrame + durationAck)
+
+			// RTS Go through source bucket
+			if deferred > maxDeferral || !c.buckets[source].In(int64(backoff+durationRTS)) {
+				return
+			}
+			rtsTX := c.occupy(source, destination, start, durationRTS)
 
-		dist := c.positionManager.Distance(source, destination)
+			// RTS is short, but not protected from collisions.
+			collided := c.collides(source, destination, start) || c.hidden(source, destination, start, durationRTS)
+			c.transmitted(source, start, durationRTS)
+			c.reserve(source, start, nav)
 
-		// Since the data frame is out in the air, interference should be put on
-		// neighbor nodes of the source node
-		for _, i := range c.positionManager.Enabled() {
-			if i == source || i == destination {
-				// source's bucket is already done;
-				// and we consider the destination's bucket later
-				continue
+			// RTS Go through destination bucket
+			if !c.buckets[destination].In(int64(durationRTS)) {
+				return
+			}
+			if collided || !c.received(rtsTX, source, destination, dist, 20, c.controlRate(rate)) {
+				return
+			}
+
+			// CTS Go through destination bucket
+			if !c.buckets[destination].In(int64(durationCTS)) {
+				return
 			}
-			d1 := c.positionManager.Distance(source, i)
-			if rand.Float64() < 1-math.Pow(d1/c.interferenceRange, 6) {
-				c.buckets[i].In(int64(durationFrame))
+			ctsTX := c.occupy(destination, source, start.Add(durationRTS), durationCTS)
+			c.transmitted(destination, start.Add(durationRTS+c.phy.sifs), durationCTS-c.phy.sifs)
+			// neighbors of destination, which may be hidden from source, hold off
+			c.reserve(destination, start.Add(durationRTS+c.phy.sifs), nav)
+
+			// CTS Go through source bucket
+			if !c.buckets[source].In(int64(durationCTS)) {
+				return
+			}
+			if !response(ctsTX, 14, c.controlRate(rate)) {
+				return
 			}
+
+			start = start.Add(durationHandshake)
+		} else if deferred > maxDeferral || !c.buckets[source].In(int64(backoff+durationFrame)) {
+			// Go through source bucket
+			return
+		}
+
+		// Since the data frame is out in the air, interference should be put on
+		// neighbor nodes of the source node; source's bucket is already done and
+		// we consider the destination's bucket later
+		dataTX := c.occupy(source, destination, start, durationFrame)
+		agg := c.openAggregate(source, destination, start, durationFrame, size, rate, useRTS)
+		if useRTS {
+			// RTS only reserved the bucket for itself
+			c.buckets[source].In(int64(durationFrame))
+		}
+
+		// With random back-off, another node may have picked the same slot.
+		// Without RTS/CTS, nodes hidden from source may be transmitting too.
+		collided := false
+		if !useRTS {
+			collided = c.collides(source, destination, start) || c.hidden(source, destination, start, durationFrame)
 		}
+		c.transmitted(source, start, durationFrame)
 
 		// data frame Go through destination bucket;
 		// we do this before dlieverRate() because no matter it's delivered or not,
@@ -199,7 +584,7 @@ func (c *csmaca) SendUnicast(source int, destination int, size int) (shouldDeliv
 		}
 
 		// The data frame takes the adventure in the air (fading, etc.)
-		if rand.Float64() > c.deliverRate(destination, dist) {
+		if collided || !c.received(dataTX, source, destination, dist, size+34, rate) {
 			return
 		}
 
@@ -211,18 +596,11 @@ func (c *csmaca) SendUnicast(source int, destination int, size int) (shouldDeliv
 		}
 
 		// ACK should be sent. Interference should be put on neighbor nodes of the
-		// destination node
-		for _, i := range c.positionManager.Enabled() {
-			if i == destination || i == source {
-				// destination's bucket is already done;
-				// we deal with source bucket later.
-				continue
-			}
-			d2 := c.positionManager.Distance(destination, i)
-			if rand.Float64() < 1-math.Pow(d2/c.interferenceRange, 6) {
-				c.buckets[i].In(int64(durationAck))
-			}
-		}
+		// destination node; destination's bucket is already done and we deal
+		// with source bucket later.
+		ackTX := c.occupy(destination, source, start.Add(durationFrame), durationAck)
+		c.transmitted(destination, start.Add(durationFrame+c.phy.sifs), durationAck-c.phy.sifs)
+		c.acked(agg, ackTX)
 
 		// ACK frame Go through source bucket;
 		// we do this before dlieverRate() because no matter it's delivered or not,
@@ -232,7 +610,7 @@ func (c *csmaca) SendUnicast(source int, destination int, size int) (shouldDeliv
 		}
 
 		// The ACK frame takes the adventure in the air (fading, etc.)
-		if rand.Float64() > c.deliverRate(destination, dist) {
+		if !response(ackTX, 14, c.controlRate(rate)) {
 			return
 		}
 
@@ -241,38 +619,85 @@ func (c *csmaca) SendUnicast(source int, destination int, size int) (shouldDeliv
 		return
 	}
 
-	for i, cw := 0, c.phy.cwMin; i < c.ucastMaxTXAttempts; i++ {
-		data, ack := usend(cw)
-		if data {
+	ifs, cwMin, cwMax, _ := c.access(ac)
+
+	// time since the first attempt started
+	var elapsed time.Duration
+	for i, cw := 0, cwMin; i < c.ucastMaxTXAttempts; i++ {
+		rate = c.rate.pick(source, destination)
+		durationFrame = c.durationOfDataFrame(size, rate)
+		durationAck = c.durationOfAckFrame(rate)
+		if useRTS {
+			durationHandshake = c.durationOfRTSFrame(rate) + c.durationOfCTSFrame(rate) + c.phy.sifs
+		}
+		exchange := durationHandshake + durationFrame + durationAck
+
+		// Retries contend again; only a first attempt may continue a TXOP.
+		deferred, continued := c.inTXOP(source, ac, exchange)
+		backoff := c.phy.sifs
+		if !continued || i > 0 {
+			deferred, backoff = c.contend(source, ifs, cw)
+			continued = false
+		}
+		attempt := deferred + backoff + durationHandshake + durationFrame
+		start := c.clock.Now().Add(deferred + backoff)
+		data, ack := usend(deferred, backoff)
+		c.rate.report(source, destination, rate, ack)
+		c.usedTXOP(source, ac, start, start.Add(exchange), continued, ack)
+		if data && !shouldDeliver {
 			shouldDeliver = true
+			d = elapsed + attempt
 		}
 		if ack {
 			break
 		}
-		if cw <= c.phy.cwMax/2 {
+		// the source waits for an ACK before it tries again
+		elapsed += attempt + durationAck
+		if cw <= cwMax/2 {
 			cw = cw*2 - 1
 		}
 	}
 
+	if shouldDeliver {
+		d += c.delay.Sample(source, destination)
+	}
 	return
 }
 
 func (c *csmaca) SendBroadcast(source int, size int, underlying []int) []int {
+	delivered, _ := c.sendBroadcast(source, size, c.classifier.Classify(size), underlying)
+	return delivered
+}
+
+// sendBroadcast returns receivers of a broadcast frame of ac, and how long
+// after it's sent the frame is received.
+func (c *csmaca) sendBroadcast(source int, size int, ac qos.AccessCategory, underlying []int) ([]int, time.Duration) {
 	if !c.positionManager.IsEnabled(source) {
-		return underlying[:0]
+		return underlying[:0], 0
 	}
 
-	durationFrame := c.durationOfDataFrame(size)
+	durationFrame := c.durationOfDataFrame(size, c.dataRateMbps)
+	ifs, cwMin, _, _ := c.access(ac)
+	deferred, backoff := c.contend(source, ifs, cwMin)
+	wait := deferred + backoff
 
 	// Go through source bucket
-	if !c.buckets[source].In(int64(c.difs + c.bo(c.phy.cwMin) + durationFrame)) {
-		return underlying[:0]
+	if deferred > maxDeferral || !c.buckets[source].In(int64(backoff+durationFrame)) {
+		return underlying[:0], 0
+	}
+
+	start := c.clock.Now().Add(wait)
+	// collisions are decided before this frame is recorded
+	defer c.transmitted(source, start, durationFrame)
+
+	if c.sinr != nil {
+		return c.sendBroadcastSINR(source, start, durationFrame, size, underlying), wait + durationFrame
 	}
 
 	count := 0
 	for _, i := range c.positionManager.Enabled() {
-		dist := c.positionManager.Distance(source, i)
-		if dist < c.transmissionRange {
+		dist := c.radios.Distance(source, i)
+		if dist < c.radios.LinkRange(source, i, 3) && c.Channel(source) == c.Channel(i) {
 
 			// Go through destination bucket. If rejected by the bucket, the
 			// broadcasted packet should not be delivered to this node;
@@ -283,17 +708,58 @@ func (c *csmaca) SendBroadcast(source int, size int, underlying []int) []int {
 			}
 
 			// The packet takes the adventure in the air (fading, etc.)
-			if rand.Float64() > c.deliverRate(i, dist) {
+			if c.collides(source, i, start) || c.random.Link("rx", source, i).Float64() > c.deliverRate(source, i, i, dist, c.dataRateMbps) {
 				continue
 			}
 
 			// The packet is gonna be delivered!
 			underlying[count] = i
 			count++
-		} else if rand.Float64() < 1-math.Pow(dist/c.interferenceRange, 6) {
-			// not in communication range, but still generating interference
+		} else if c.interferes(source, i) {
+			// not in communication range or on another channel, but still
+			// generating interference
 			c.buckets[i].In(int64(durationFrame))
 		}
 	}
+	return underlying[:count], wait + durationFrame
+}
+
+func (c *csmaca) SendBroadcastWithDelay(source int, size int, underlying []int, delays []time.Duration) ([]int, []time.Duration) {
+	return c.SendBroadcastWithCategory(source, size, c.classifier.Classify(size), underlying, delays)
+}
+
+// SendBroadcastWithCategory implements qos.September.
+func (c *csmaca) SendBroadcastWithCategory(source int, size int, ac qos.AccessCategory, underlying []int, delays []time.Duration) ([]int, []time.Duration) {
+	if !ac.Valid() {
+		ac = qos.BestEffort
+	}
+	delivered, air := c.sendBroadcast(source, size, ac, underlying)
+	for i, dest := range delivered {
+		delays[i] = air + c.delay.Sample(source, dest)
+	}
+	return delivered, delays[:len(delivered)]
+}
+
+func (c *csmaca) sendBroadcastSINR(source int, start time.Time, durationFrame time.Duration, size int, underlying []int) []int {
+	tx := c.sinr.transmit(source, start, durationFrame)
+	count := 0
+	for _, i := range c.positionManager.Enabled() {
+		if i == source || c.rxPower(source, i) < c.sinr.ccaThreshold {
+			continue
+		}
+
+		// Nodes that hear the frame get their bucket occupied. If rejected by
+		// the bucket, the broadcasted packet should not be delivered to them.
+		if !c.buckets[i].In(int64(durationFrame)) {
+			continue
+		}
+
+		if c.collides(source, i, start) || !c.received(tx, source, i, 0, size+34, c.dataRateMbps) {
+			continue
+		}
+
+		underlying[count] = i
+		count++
+	}
 	return underlying[:count]
 }
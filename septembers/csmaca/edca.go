@@ -0,0 +1,173 @@
+package csmaca
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/qos"
+)
+
+const edcaParametersHelp = `
+  "edca":               bool, optional, default false;
+                        Makes access categories (background, best_effort,
+                        video and voice) contend as in 802.11e EDCA, each
+                        with its own AIFS of SIFS plus aifsn slots,
+                        contention window and TXOP: within a TXOP, packets
+                        that are already waiting go SIFS after the previous
+                        ACK, without back-off. Otherwise all packets contend
+                        as in DCF, with DIFS. Defaults are those of 802.11,
+                        or of 802.11p outside the context of a BSS for
+                        802.11p protocols, where TXOP is 0 (a frame per
+                        access).
+  "edca_parameters":    directory, optional;
+                        Overrides defaults of an access category, e.g.
+                        "edca_parameters/voice/aifsn": "2". Keys are "aifsn",
+                        "cw_min", "cw_max" and "txop_us".
+Packets sent through SendUnicast and SendBroadcast get their access category
+from the classifier below.` + qos.ParametersHelp
+
+// edcaParameters are how frames of an access category contend.
+type edcaParameters struct {
+	aifsn int
+	cwMin int // # slots
+	cwMax int // # slots
+	txop  time.Duration
+}
+
+// defaultEDCA returns default parameters of every access category on p,
+// indexed by qos.AccessCategory. ocb is for 802.11p outside the context of a
+// BSS.
+func defaultEDCA(p *phy, ocb bool) []edcaParameters {
+	vi, vo := (p.cwMin+1)/2-1, (p.cwMin+1)/4-1
+	if ocb {
+		return []edcaParameters{
+			qos.Background: {9, p.cwMin, p.cwMax, 0},
+			qos.BestEffort: {6, p.cwMin, p.cwMax, 0},
+			qos.Video:      {3, vi, p.cwMin, 0},
+			qos.Voice:      {2, vo, vi, 0},
+		}
+	}
+	txopVI, txopVO := 3008*time.Microsecond, 1504*time.Microsecond
+	if p.symbol == 0 { // DSSS
+		txopVI, txopVO = 6016*time.Microsecond, 3264*time.Microsecond
+	}
+	return []edcaParameters{
+		qos.Background: {7, p.cwMin, p.cwMax, 0},
+		qos.BestEffort: {3, p.cwMin, p.cwMax, 0},
+		qos.Video:      {2, vi, p.cwMin, txopVI},
+		qos.Voice:      {2, vo, vi, txopVO},
+	}
+}
+
+// configureEDCA returns EDCA parameters conf asks for, or nil if EDCA is off.
+func configureEDCA(conf *etcd.Node, p *phy, ocb bool) (ret []edcaParameters, err error) {
+	on := false
+	var overrides *etcd.Node
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "/edca") {
+			if on, err = strconv.ParseBool(node.Value); err != nil {
+				return
+			}
+		} else if node.Dir && strings.HasSuffix(node.Key, "/edca_parameters") {
+			overrides = node
+		}
+	}
+	if !on {
+		return nil, nil
+	}
+
+	ret = defaultEDCA(p, ocb)
+	if overrides == nil {
+		return
+	}
+	for _, dir := range overrides.Nodes {
+		var ac qos.AccessCategory
+		if ac, err = qos.Parse(dir.Key[strings.LastIndex(dir.Key, "/")+1:]); err != nil {
+			return
+		}
+		params := &ret[ac]
+		for _, node := range dir.Nodes {
+			var v int
+			if v, err = strconv.Atoi(node.Value); err != nil {
+				return
+			}
+			switch node.Key[strings.LastIndex(node.Key, "/")+1:] {
+			case "aifsn":
+				params.aifsn = v
+			case "cw_min":
+				params.cwMin = v
+			case "cw_max":
+				params.cwMax = v
+			case "txop_us":
+				params.txop = time.Duration(v) * time.Microsecond
+			default:
+				return nil, fmt.Errorf("edca_parameters: unknown key %s", node.Key)
+			}
+		}
+		if params.aifsn < 1 || params.cwMin < 0 || params.cwMax < params.cwMin || params.txop < 0 {
+			return nil, errors.New("edca_parameters: invalid parameters of " + ac.String())
+		}
+	}
+	return
+}
+
+// access returns how frames of ac contend: the interframe space before
+// back-off, bounds of the contention window, and the TXOP limit.
+func (c *csmaca) access(ac qos.AccessCategory) (ifs time.Duration, cwMin int, cwMax int, txop time.Duration) {
+	if c.edca == nil {
+		return c.difs, c.phy.cwMin, c.phy.cwMax, 0
+	}
+	p := c.edca[ac]
+	return c.phy.sifs + time.Duration(p.aifsn)*c.phy.slot, p.cwMin, p.cwMax, p.txop
+}
+
+// txopState is the TXOP each access category of a node holds.
+type txopState struct {
+	mu    sync.Mutex
+	limit [4]time.Time // the TXOP ends
+	last  [4]time.Time // the last frame exchange in it ends
+}
+
+// inTXOP tells whether a frame exchange of ac lasting d can go from source in
+// a TXOP it holds, and if so, how long it waits before going SIFS after the
+// previous exchange. Only packets that arrive before the previous exchange is
+// over continue a TXOP; otherwise source has to contend again.
+func (c *csmaca) inTXOP(source int, ac qos.AccessCategory, d time.Duration) (wait time.Duration, ok bool) {
+	if c.txops == nil {
+		return
+	}
+	now := c.clock.Now()
+	t := c.txops[source]
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last[ac].Before(now) || t.last[ac].Add(c.phy.sifs+d).After(t.limit[ac]) {
+		return
+	}
+	return t.last[ac].Sub(now), true
+}
+
+// usedTXOP records a frame exchange of ac from source from start until end.
+// A new TXOP begins with it unless continued is set. A failed exchange ends
+// the TXOP.
+func (c *csmaca) usedTXOP(source int, ac qos.AccessCategory, start time.Time, end time.Time, continued bool, ok bool) {
+	_, _, _, txop := c.access(ac)
+	if c.txops == nil || txop == 0 {
+		return
+	}
+	t := c.txops[source]
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !ok {
+		t.last[ac] = time.Time{}
+		return
+	}
+	if !continued {
+		t.limit[ac] = start.Add(txop)
+	}
+	t.last[ac] = end
+}
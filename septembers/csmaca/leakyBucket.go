@@ -1,52 +1,59 @@
 package csmaca
 
 import (
-	"sync/atomic"
+	"sync"
 	"time"
+
+	"github.com/squirrel-land/models/septembers/clock"
 )
 
-// Thread-safe leaky bucket
+// Thread-safe leaky bucket. Instead of draining on a ticker, it computes how
+// much has leaked since it was last touched, by its clock.
 type leakyBucket struct {
-	bucketSize        int64
-	waterDropInterval time.Duration
-	waterDropSize     int64
+	bucketSize int64
+	drainRate  float64 // per nanosecond
 
-	bucket  int64
-	started bool
+	mu     sync.Mutex
+	clock  clock.Clock
+	bucket float64
+	last   time.Time
 }
 
-func NewLeakyBucket(bucketSize int, waterDropInterval time.Duration, waterDropSize int) *leakyBucket {
+func NewLeakyBucket(bucketSize int, waterDropInterval time.Duration, waterDropSize int, c clock.Clock) *leakyBucket {
 	return &leakyBucket{
-		bucketSize:        int64(bucketSize),
-		waterDropInterval: waterDropInterval,
-		waterDropSize:     int64(waterDropSize),
+		bucketSize: int64(bucketSize),
+		drainRate:  float64(waterDropSize) / float64(waterDropInterval),
+		clock:      c,
+		last:       c.Now(),
 	}
 }
 
-func (this *leakyBucket) In(size int64) bool {
-	if atomic.LoadInt64(&this.bucket) > this.bucketSize {
-		return false
+// drain has to be called with b.mu held.
+func (b *leakyBucket) drain() {
+	now := b.clock.Now()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.bucket -= float64(elapsed) * b.drainRate
+		if b.bucket < 0 {
+			b.bucket = 0
+		}
 	}
-	atomic.AddInt64(&this.bucket, size)
-	return true
+	b.last = now
 }
 
-func (b *leakyBucket) Go() {
-	if !b.started {
-		b.started = true
-		go func() {
-			ticker := time.NewTicker(b.waterDropInterval)
-			for _ = range ticker.C {
-				if atomic.LoadInt64(&b.bucket) > 0 {
-					atomic.AddInt64(&b.bucket, -b.waterDropSize)
-				}
-			}
-		}()
-	} else {
-		panic("leaky bucket Go() called more than once")
+func (b *leakyBucket) In(size int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drain()
+	if b.bucket > float64(b.bucketSize) {
+		return false
 	}
+	b.bucket += float64(size)
+	return true
 }
 
 func (b *leakyBucket) Usage() float64 {
-	return float64(atomic.LoadInt64(&b.bucket)) / float64(b.bucketSize)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drain()
+	return b.bucket / float64(b.bucketSize)
 }
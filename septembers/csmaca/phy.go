@@ -1,43 +1,259 @@
 package csmaca
 
-import "time"
+import (
+	"errors"
+	"math"
+	"time"
+)
 
 type phy struct {
 	slot     time.Duration
 	sifs     time.Duration
-	preamble time.Duration
+	preamble time.Duration // PLCP preamble
+	header   time.Duration // PLCP header, if it's not part of preamble
+	symbol   time.Duration // OFDM symbol with guard interval; 0 for DSSS
 
 	cwMin int // # slots
 	cwMax int // # slots
+
+	rates []float64 // Mbps, ascending
+	basic []float64 // basic rates, in Mbps and ascending, of control frames
+
+	// control is the phy of control frames if it's not this one: HT, VHT and
+	// HE send them in non-HT format.
+	control *phy
+
+	maxAMPDU int // bytes; 0 if A-MPDU isn't supported
 }
 
 var phyOFDM20 *phy = &phy{
 	slot:     9 * time.Microsecond,
 	sifs:     16 * time.Microsecond,
 	preamble: 16 * time.Microsecond,
+	header:   4 * time.Microsecond, // SIGNAL
+	symbol:   4 * time.Microsecond,
 	cwMin:    15,
 	cwMax:    1023,
+	rates:    []float64{6, 9, 12, 18, 24, 36, 48, 54},
+	basic:    []float64{6, 12, 24},
 }
 
 var phyOFDM10 *phy = &phy{
 	slot:     13 * time.Microsecond,
 	sifs:     32 * time.Microsecond,
 	preamble: 32 * time.Microsecond,
+	header:   8 * time.Microsecond, // SIGNAL
+	symbol:   8 * time.Microsecond,
 	cwMin:    15,
 	cwMax:    1023,
+	rates:    []float64{3, 4.5, 6, 9, 12, 18, 24, 27},
+	basic:    []float64{3, 6, 12},
 }
 
 var phyOFDM5 *phy = &phy{
 	slot:     21 * time.Microsecond,
 	sifs:     64 * time.Microsecond,
 	preamble: 64 * time.Microsecond,
+	header:   16 * time.Microsecond, // SIGNAL
+	symbol:   16 * time.Microsecond,
 	cwMin:    15,
 	cwMax:    1023,
+	rates:    []float64{1.5, 2.25, 3, 4.5, 6, 9, 12, 13.5},
+	basic:    []float64{1.5, 3, 6},
 }
 
 var (
 	phy80211a   = phyOFDM20
 	phy80211g   = phyOFDM20
+	phy80211p5  = phyOFDM5
 	phy80211p10 = phyOFDM10
 	phy80211p20 = phyOFDM20
 )
+
+// DSSS/CCK of 802.11b. The short preamble can't carry 1 Mbps.
+var phyDSSSLong *phy = &phy{
+	slot:     20 * time.Microsecond,
+	sifs:     10 * time.Microsecond,
+	preamble: 144 * time.Microsecond,
+	header:   48 * time.Microsecond,
+	cwMin:    31,
+	cwMax:    1023,
+	rates:    []float64{1, 2, 5.5, 11},
+	basic:    []float64{1, 2},
+}
+
+var phyDSSSShort *phy = &phy{
+	slot:     20 * time.Microsecond,
+	sifs:     10 * time.Microsecond,
+	preamble: 72 * time.Microsecond,
+	header:   24 * time.Microsecond,
+	cwMin:    31,
+	cwMax:    1023,
+	rates:    []float64{2, 5.5, 11},
+	basic:    []float64{2},
+}
+
+// mcs is a modulation and coding scheme: bits per subcarrier and code rate.
+type mcs struct {
+	bits int
+	code float64
+}
+
+// MCS 0 to 11; HT uses the first 8, VHT the first 10 and HE all of them.
+var mcsTable = []mcs{
+	{1, 1. / 2}, {2, 1. / 2}, {2, 3. / 4}, {4, 1. / 2}, {4, 3. / 4}, {6, 2. / 3},
+	{6, 3. / 4}, {6, 5. / 6}, {8, 3. / 4}, {8, 5. / 6}, {10, 3. / 4}, {10, 5. / 6},
+}
+
+// mimo describes one of HT, VHT and HE.
+type mimo struct {
+	mcs       int         // # of MCSs
+	streams   int         // max # of spatial streams
+	widths    map[int]int // channel width in MHz -> # of data subcarriers
+	symbol    time.Duration
+	gis       []time.Duration // guard intervals allowed, the first is the default
+	preamble  time.Duration   // up to the first long training field, SIG fields included
+	ltf       time.Duration   // per long training field, without guard interval
+	ltfWithGI bool            // whether long training fields carry the data guard interval
+	maxAMPDU  int             // bytes
+}
+
+var (
+	mimoHT = &mimo{
+		mcs:      8,
+		streams:  4,
+		widths:   map[int]int{20: 52, 40: 108},
+		symbol:   3200 * time.Nanosecond,
+		gis:      []time.Duration{800 * time.Nanosecond, 400 * time.Nanosecond},
+		preamble: 32 * time.Microsecond, // L-STF, L-LTF, L-SIG, HT-SIG, HT-STF
+		ltf:      4 * time.Microsecond,
+		maxAMPDU: 65535,
+	}
+	mimoVHT = &mimo{
+		mcs:      10,
+		streams:  8,
+		widths:   map[int]int{20: 52, 40: 108, 80: 234, 160: 468},
+		symbol:   3200 * time.Nanosecond,
+		gis:      []time.Duration{800 * time.Nanosecond, 400 * time.Nanosecond},
+		preamble: 36 * time.Microsecond, // L-STF, L-LTF, L-SIG, VHT-SIG-A, VHT-STF, VHT-SIG-B
+		ltf:      4 * time.Microsecond,
+		maxAMPDU: 1048575,
+	}
+	mimoHE = &mimo{
+		mcs:       12,
+		streams:   8,
+		widths:    map[int]int{20: 234, 40: 468, 80: 980, 160: 1960},
+		symbol:    12800 * time.Nanosecond,
+		gis:       []time.Duration{800 * time.Nanosecond, 1600 * time.Nanosecond, 3200 * time.Nanosecond},
+		preamble:  36 * time.Microsecond,  // L-STF, L-LTF, L-SIG, RL-SIG, HE-SIG-A, HE-STF
+		ltf:       6400 * time.Nanosecond, // 2x HE-LTF
+		ltfWithGI: true,
+		maxAMPDU:  6500631,
+	}
+)
+
+// newMIMOPHY returns the phy of m in the 5 GHz band with channel width in MHz,
+// guard interval gi (0 for m's default) and streams spatial streams. Rates,
+// ascending, are those of every MCS that gives a whole number of data bits per
+// symbol.
+func newMIMOPHY(m *mimo, width int, gi time.Duration, streams int) (*phy, error) {
+	subcarriers, ok := m.widths[width]
+	if !ok {
+		return nil, errors.New("channel_width_mhz not supported by mac_protocol")
+	}
+	if gi == 0 {
+		gi = m.gis[0]
+	}
+	allowed := false
+	for _, g := range m.gis {
+		allowed = allowed || g == gi
+	}
+	if !allowed {
+		return nil, errors.New("guard_interval_ns not supported by mac_protocol")
+	}
+	if streams < 1 || streams > m.streams {
+		return nil, errors.New("spatial_streams not supported by mac_protocol")
+	}
+
+	p := &phy{
+		slot:     9 * time.Microsecond,
+		sifs:     16 * time.Microsecond,
+		preamble: m.preamble + time.Duration(ltfs(streams))*m.ltf,
+		symbol:   m.symbol + gi,
+		cwMin:    15,
+		cwMax:    1023,
+		basic:    phyOFDM20.basic,
+		control:  phyOFDM20,
+		maxAMPDU: m.maxAMPDU,
+	}
+	if m.ltfWithGI {
+		p.preamble += time.Duration(ltfs(streams)) * gi
+	}
+	symbol := float64(m.symbol+gi) / float64(time.Microsecond)
+	for _, s := range mcsTable[:m.mcs] {
+		bits := float64(subcarriers*s.bits*streams) * s.code // per symbol
+		if bits != math.Floor(bits) {
+			continue
+		}
+		p.rates = append(p.rates, bits/symbol)
+	}
+	return p, nil
+}
+
+// ltfs returns the number of long training fields for streams spatial streams.
+func ltfs(streams int) int {
+	if streams%2 == 1 && streams > 1 {
+		return streams + 1
+	}
+	return streams
+}
+
+// airtime returns how long a PPDU carrying bytes at rate Mbps lasts: PLCP
+// preamble and header, then SERVICE (16 bits), data and tail (6 bits) bits in
+// whole OFDM symbols. DSSS has no symbols, but rounds up to microseconds.
+func (p *phy) airtime(bytes int, rate float64) time.Duration {
+	d := p.preamble + p.header
+	if p.symbol == 0 {
+		return d + time.Duration(math.Ceil(float64(8*bytes)/rate))*time.Microsecond
+	}
+	symbols := math.Ceil(float64(16+8*bytes+6) / p.bitsPerSymbol(rate))
+	return d + time.Duration(symbols)*p.symbol
+}
+
+func (p *phy) bitsPerSymbol(rate float64) float64 {
+	return math.Round(rate * float64(p.symbol) / float64(time.Microsecond))
+}
+
+// supports tells whether p can send frames at rate Mbps: DSSS has only its
+// rates, and OFDM symbols have to carry at least a bit.
+func (p *phy) supports(rate float64) bool {
+	if p.symbol == 0 {
+		for _, r := range p.rates {
+			if r == rate {
+				return true
+			}
+		}
+		return false
+	}
+	return rate > 0 && p.bitsPerSymbol(rate) >= 1
+}
+
+// basicRate returns the rate of a control frame answering a frame at rate
+// Mbps: the highest basic rate not above it, or the lowest basic rate.
+func (p *phy) basicRate(rate float64) float64 {
+	ret := p.basic[0]
+	for _, b := range p.basic {
+		if b <= rate {
+			ret = b
+		}
+	}
+	return ret
+}
+
+// controlPHY returns the phy control frames are sent with.
+func (p *phy) controlPHY() *phy {
+	if p.control != nil {
+		return p.control
+	}
+	return p
+}
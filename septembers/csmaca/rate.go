@@ -0,0 +1,259 @@
+package csmaca
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/random"
+)
+
+const rateParametersHelp = `
+  "rate_control":       string, optional, default "fixed";
+                        How unicast data frames pick their rate on each link:
+                        "fixed" always uses data_rate_mbps; "table" picks the
+                        highest rate in rate_table that the link qualifies
+                        for; "arf" steps up after 10 ACKed frames in a row
+                        and down after 2 failures in a row; "minstrel" keeps
+                        a moving average of success of every rate and picks
+                        the one with the best expected throughput, sampling
+                        others 10% of the time. Broadcast frames always use
+                        data_rate_mbps. RTS, CTS and ACK frames go at the
+                        highest basic rate of the mac_protocol not above the
+                        rate of the data frame, or the lowest basic rate.
+                        Rates other than data_rate_mbps need about 3 dB more
+                        SNR per doubling: the bucket model shrinks
+                        transmission_range with (data_rate_mbps/rate)^(1/3),
+                        and the sinr model raises sinr_threshold_db for rates
+                        without a per_table entry.
+  "rate_table":         directory, required by "table";
+                        Keyed by rate in Mbps. Values are the minimum mean
+                        SNR in dB with the sinr reception model, or the
+                        maximum distance otherwise, e.g. "rate_table/54": "25".
+                        The lowest rate of the mac_protocol is used when the
+                        link qualifies for none.
+`
+
+// rateControl picks data rates, in Mbps, for unicast frames on each link.
+type rateControl interface {
+	pick(src int, dest int) float64
+	// report tells how a frame sent at rate did: ok if it was ACKed.
+	report(src int, dest int, rate float64, ok bool)
+}
+
+// configureRate returns the rateControl conf asks for. rates are those of the
+// phy in ascending order, and qualifies tells whether a link meets a
+// rate_table value.
+func configureRate(conf *etcd.Node, fixed float64, rates []float64, qualifies func(src int, dest int, threshold float64) bool, rnd *random.Source) (rateControl, error) {
+	kind := "fixed"
+	var table *etcd.Node
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "/rate_control") {
+			kind = node.Value
+		} else if node.Dir && strings.HasSuffix(node.Key, "/rate_table") {
+			table = node
+		}
+	}
+
+	switch kind {
+	case "fixed":
+		return fixedRate(fixed), nil
+	case "table":
+		if table == nil {
+			return nil, errors.New("rate_table is required by rate_control table")
+		}
+		return newTableRate(table, rates[0], qualifies)
+	case "arf":
+		return &arf{rates: rates, links: make(map[[2]int]*arfState)}, nil
+	case "minstrel":
+		return &minstrel{rates: rates, random: rnd, links: make(map[[2]int][]float64)}, nil
+	}
+	return nil, errors.New("unknown rate_control")
+}
+
+type fixedRate float64
+
+func (f fixedRate) pick(src int, dest int) float64                  { return float64(f) }
+func (f fixedRate) report(src int, dest int, rate float64, ok bool) {}
+
+type rateThreshold struct {
+	rate      float64
+	threshold float64
+}
+
+// tableRate picks rates by a static table of SNR or distance.
+type tableRate struct {
+	entries   []rateThreshold // highest rate first
+	lowest    float64
+	qualifies func(src int, dest int, threshold float64) bool
+}
+
+func newTableRate(dir *etcd.Node, lowest float64, qualifies func(src int, dest int, threshold float64) bool) (*tableRate, error) {
+	t := &tableRate{lowest: lowest, qualifies: qualifies}
+	for _, node := range dir.Nodes {
+		rate, err := strconv.ParseFloat(node.Key[strings.LastIndex(node.Key, "/")+1:], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("rate_table: invalid rate in %s", node.Key)
+		}
+		threshold, err := strconv.ParseFloat(node.Value, 64)
+		if err != nil {
+			return nil, err
+		}
+		t.entries = append(t.entries, rateThreshold{rate, threshold})
+	}
+	sort.Sort(byRateDesc(t.entries))
+	return t, nil
+}
+
+func (t *tableRate) pick(src int, dest int) float64 {
+	for _, e := range t.entries {
+		if t.qualifies(src, dest, e.threshold) {
+			return e.rate
+		}
+	}
+	return t.lowest
+}
+
+func (t *tableRate) report(src int, dest int, rate float64, ok bool) {}
+
+type byRateDesc []rateThreshold
+
+func (r byRateDesc) Len() int           { return len(r) }
+func (r byRateDesc) Less(i, j int) bool { return r[i].rate > r[j].rate }
+func (r byRateDesc) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+const (
+	arfUp   = 10 // successes in a row before trying a higher rate
+	arfDown = 2  // failures in a row before falling back
+)
+
+type arfState struct {
+	index     int
+	successes int
+	failures  int
+	probing   bool // the first frame at a rate just stepped up to
+}
+
+// arf is Auto Rate Fallback.
+type arf struct {
+	rates []float64
+
+	mu    sync.Mutex
+	links map[[2]int]*arfState
+}
+
+func (a *arf) state(src int, dest int) *arfState {
+	s, ok := a.links[[2]int{src, dest}]
+	if !ok {
+		// start from the top and fall back as needed
+		s = &arfState{index: len(a.rates) - 1}
+		a.links[[2]int{src, dest}] = s
+	}
+	return s
+}
+
+func (a *arf) pick(src int, dest int) float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rates[a.state(src, dest).index]
+}
+
+func (a *arf) report(src int, dest int, rate float64, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.state(src, dest)
+	if ok {
+		s.successes, s.failures, s.probing = s.successes+1, 0, false
+		if s.successes >= arfUp && s.index < len(a.rates)-1 {
+			s.index, s.successes, s.probing = s.index+1, 0, true
+		}
+		return
+	}
+	s.successes, s.failures = 0, s.failures+1
+	if (s.probing || s.failures >= arfDown) && s.index > 0 {
+		s.index, s.failures = s.index-1, 0
+	}
+	s.probing = false
+}
+
+const (
+	minstrelWeight = .25 // of the latest outcome in the moving average
+	minstrelSample = .1  // share of frames sent at a random rate
+)
+
+// minstrel picks the rate with the best expected throughput, based on moving
+// averages of success of each rate.
+type minstrel struct {
+	rates  []float64
+	random *random.Source
+
+	mu    sync.Mutex
+	links map[[2]int][]float64 // success probability per rate
+}
+
+func (m *minstrel) probabilities(src int, dest int) []float64 {
+	p, ok := m.links[[2]int{src, dest}]
+	if !ok {
+		p = make([]float64, len(m.rates))
+		for i := range p {
+			p[i] = 1 // optimistic until shown otherwise
+		}
+		m.links[[2]int{src, dest}] = p
+	}
+	return p
+}
+
+func (m *minstrel) pick(src int, dest int) float64 {
+	r := m.random.Link("minstrel", src, dest)
+	if r.Float64() < minstrelSample {
+		return m.rates[r.Intn(len(m.rates))]
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	best, bestThroughput := 0, -1.
+	for i, p := range m.probabilities(src, dest) {
+		if throughput := p * m.rates[i]; throughput > bestThroughput {
+			best, bestThroughput = i, throughput
+		}
+	}
+	return m.rates[best]
+}
+
+func (m *minstrel) report(src int, dest int, rate float64, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.probabilities(src, dest)
+	for i, r := range m.rates {
+		if r == rate {
+			outcome := 0.
+			if ok {
+				outcome = 1
+			}
+			p[i] = (1-minstrelWeight)*p[i] + minstrelWeight*outcome
+			return
+		}
+	}
+}
+
+// rangeAt returns transmission range of the bucket model from src to dest at
+// rate Mbps.
+func (c *csmaca) rangeAt(src int, dest int, rate float64) float64 {
+	return c.radios.LinkRange(src, dest, 3) * math.Pow(c.dataRateMbps/rate, 1./3)
+}
+
+// qualifies tells whether the link from src to dest meets a rate_table value:
+// minimum mean SNR with the sinr reception model, or maximum distance
+// otherwise.
+func (c *csmaca) qualifies(src int, dest int, threshold float64) bool {
+	dist := c.radios.Distance(src, dest)
+	if c.sinr != nil {
+		return c.radios.Of(src).TxPowerDbm-c.sinr.pathLoss.MeanLoss(dist)-mW2dBm(c.sinr.noise) >= threshold
+	}
+	return dist <= threshold
+}
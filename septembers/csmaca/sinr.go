@@ -0,0 +1,304 @@
+package csmaca
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/clock"
+	"github.com/squirrel-land/models/septembers/radio"
+)
+
+const sinrParametersHelp = `
+With "reception_model" set to "sinr", frames are received based on their SINR
+at the receiver, where interference is the sum of all transmissions that
+overlap in time. transmission_range and interference_range are not used.
+
+  "reception_model":    string, optional, default "bucket";
+                        "bucket" or "sinr".
+  "tx_power_dbm":       float64, required by sinr;
+                        Transmission power in dBm.
+  "reference_loss_db":  float64, required by sinr;
+                        Path loss in dB at reference_distance.
+  "reference_distance": float64, optional, default 1;
+                        Distance where reference_loss_db is measured, in the
+                        same unit as node positions.
+  "path_loss_exponent": float64, required by sinr;
+                        Path loss exponent.
+  "shadowing_sigma_db": float64, optional, default 0;
+                        Standard deviation of log-normal shadowing in dB.
+  "noise_floor_dbm":    float64, optional, default -95;
+                        Thermal noise plus receiver noise figure.
+  "cca_threshold_dbm":  float64, optional, default -82;
+                        Received power above which a node senses the medium
+                        busy.
+  "sinr_threshold_db":  float64;
+                        Frames with SINR above this are received. Required
+                        unless per_table has an entry for the data rate.
+  "per_table":          directory, optional;
+                        PER tables keyed by data rate in Mbps, each a list of
+                        "sinr_db:per" pairs, e.g. "per_table/6": "2:1,5:0.1,
+                        8:0". PER is interpolated linearly between points.
+  "per_table_size":     int, optional, default 1000;
+                        Frame size in bytes the PER tables refer to.
+`
+
+// transmission is a frame in the air.
+type transmission struct {
+	source int
+	start  time.Time
+	end    time.Time
+}
+
+type perPoint struct {
+	sinr float64 // dB
+	per  float64
+}
+
+// sinrModel keeps track of concurrent transmissions and decides reception
+// based on signal to interference plus noise ratio.
+type sinrModel struct {
+	txPower      float64 // dBm
+	pathLoss     radio.LogDistance
+	noise        float64 // mW
+	ccaThreshold float64 // dBm
+	threshold    float64 // dB
+	perTables    map[float64][]perPoint
+	perSize      int
+	baseRate     float64 // Mbps that threshold is for
+	clock        clock.Clock
+
+	mu            sync.Mutex
+	transmissions []*transmission
+}
+
+// configureSINR returns a sinrModel if conf asks for the sinr reception model,
+// or nil otherwise.
+func configureSINR(conf *etcd.Node) (s *sinrModel, err error) {
+	model := "bucket"
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "reception_model") {
+			model = node.Value
+		}
+	}
+	switch model {
+	case "bucket":
+		return nil, nil
+	case "sinr":
+	default:
+		return nil, errors.New("unknown reception_model")
+	}
+
+	s = &sinrModel{
+		ccaThreshold: -82,
+		threshold:    math.NaN(),
+		perTables:    make(map[float64][]perPoint),
+		perSize:      1000,
+	}
+	s.pathLoss.ReferenceDistance = 1
+	noise := -95.
+	var txPowerFound, referenceLossFound bool
+	for _, node := range conf.Nodes {
+		if node.Dir && strings.HasSuffix(node.Key, "per_table") {
+			if err = s.parsePERTables(node); err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "tx_power_dbm") {
+			s.txPower, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+			txPowerFound = true
+		} else if !node.Dir && strings.HasSuffix(node.Key, "reference_loss_db") {
+			s.pathLoss.ReferenceLoss, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+			referenceLossFound = true
+		} else if !node.Dir && strings.HasSuffix(node.Key, "reference_distance") {
+			s.pathLoss.ReferenceDistance, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "path_loss_exponent") {
+			s.pathLoss.Exponent, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "shadowing_sigma_db") {
+			s.pathLoss.Sigma, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "noise_floor_dbm") {
+			noise, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "cca_threshold_dbm") {
+			s.ccaThreshold, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "sinr_threshold_db") {
+			s.threshold, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "per_table_size") {
+			s.perSize, err = strconv.Atoi(node.Value)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	var errorParameters []string
+	if !txPowerFound {
+		errorParameters = append(errorParameters, "tx_power_dbm")
+	}
+	if !referenceLossFound {
+		errorParameters = append(errorParameters, "reference_loss_db")
+	}
+	if s.pathLoss.ReferenceDistance <= 0 {
+		errorParameters = append(errorParameters, "reference_distance")
+	}
+	if s.pathLoss.Exponent <= 0 {
+		errorParameters = append(errorParameters, "path_loss_exponent")
+	}
+	if s.pathLoss.Sigma < 0 {
+		errorParameters = append(errorParameters, "shadowing_sigma_db")
+	}
+	if s.perSize <= 0 {
+		errorParameters = append(errorParameters, "per_table_size")
+	}
+	if len(errorParameters) != 0 {
+		err = fmt.Errorf("parameter(s) missing or invalid: %v", errorParameters)
+		return
+	}
+
+	s.noise = dBm2mW(noise)
+	return
+}
+
+func (s *sinrModel) parsePERTables(dir *etcd.Node) error {
+	for _, node := range dir.Nodes {
+		rate, err := strconv.ParseFloat(node.Key[strings.LastIndex(node.Key, "/")+1:], 64)
+		if err != nil {
+			return fmt.Errorf("per_table: invalid data rate in %s", node.Key)
+		}
+		var table []perPoint
+		for _, pair := range strings.Split(node.Value, ",") {
+			fields := strings.Split(strings.TrimSpace(pair), ":")
+			if len(fields) != 2 {
+				return fmt.Errorf("per_table: invalid point %q", pair)
+			}
+			var p perPoint
+			if p.sinr, err = strconv.ParseFloat(fields[0], 64); err != nil {
+				return err
+			}
+			if p.per, err = strconv.ParseFloat(fields[1], 64); err != nil {
+				return err
+			}
+			if p.per < 0 || p.per > 1 {
+				return fmt.Errorf("per_table: PER out of [0, 1] in %q", pair)
+			}
+			table = append(table, p)
+		}
+		sort.Sort(bySINR(table))
+		s.perTables[rate] = table
+	}
+	return nil
+}
+
+// check makes sure there is a way to decide reception at rate Mbps.
+func (s *sinrModel) check(rate float64) error {
+	if _, ok := s.perTables[rate]; !ok && math.IsNaN(s.threshold) {
+		return fmt.Errorf("sinr: neither sinr_threshold_db nor per_table for %v Mbps is given", rate)
+	}
+	return nil
+}
+
+func dBm2mW(dBm float64) float64 {
+	return math.Pow(10, dBm/10)
+}
+
+func mW2dBm(mW float64) float64 {
+	return 10 * math.Log10(mW)
+}
+
+// transmit puts a frame from source that lasts d from start into the air.
+// Frames are decided when they're sent, so start may be in the future.
+func (s *sinrModel) transmit(source int, start time.Time, d time.Duration) *transmission {
+	now := s.clock.Now()
+	tx := &transmission{source: source, start: start, end: start.Add(d)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active := s.transmissions[:0]
+	for _, t := range s.transmissions {
+		if t.end.After(now) {
+			active = append(active, t)
+		}
+	}
+	s.transmissions = append(active, tx)
+	return tx
+}
+
+// interferers returns sources of transmissions in the air along with tx.
+func (s *sinrModel) interferers(tx *transmission) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret []int
+	for _, t := range s.transmissions {
+		if t != tx && t.source != tx.source && t.start.Before(tx.end) && t.end.After(tx.start) {
+			ret = append(ret, t.source)
+		}
+	}
+	return ret
+}
+
+// perAt returns packet error rate of a frame of size bytes at sinr dB and rate
+// Mbps.
+func (s *sinrModel) perAt(sinr float64, rate float64, size int) float64 {
+	table, ok := s.perTables[rate]
+	if !ok {
+		// about 3 dB more per doubling of the rate
+		if sinr >= s.threshold+10*math.Log10(rate/s.baseRate) {
+			return 0
+		}
+		return 1
+	}
+
+	var per float64
+	switch i := sort.Search(len(table), func(i int) bool { return table[i].sinr >= sinr }); {
+	case i == 0:
+		per = table[0].per
+	case i == len(table):
+		per = table[len(table)-1].per
+	default:
+		a, b := table[i-1], table[i]
+		per = a.per + (b.per-a.per)*(sinr-a.sinr)/(b.sinr-a.sinr)
+	}
+	// bits are assumed to be lost independently
+	return 1 - math.Pow(1-per, float64(size)/float64(s.perSize))
+}
+
+type bySINR []perPoint
+
+func (t bySINR) Len() int           { return len(t) }
+func (t bySINR) Less(i, j int) bool { return t[i].sinr < t[j].sinr }
+func (t bySINR) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+// shift moves tx later by d.
+func (s *sinrModel) shift(tx *transmission, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx.start, tx.end = tx.start.Add(d), tx.end.Add(d)
+}
@@ -0,0 +1,121 @@
+package delay
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/random"
+	"github.com/squirrel-land/squirrel"
+)
+
+// September is a squirrel.September that also tells how long after being sent
+// a packet reaches each receiver. Emulators that don't know about it keep
+// calling SendUnicast and SendBroadcast, which deliver without delay.
+type September interface {
+	squirrel.September
+
+	// SendUnicastWithDelay is SendUnicast that also returns the delivery delay.
+	SendUnicastWithDelay(source int, destination int, size int) (bool, time.Duration)
+
+	// SendBroadcastWithDelay is SendBroadcast that also returns delivery delays
+	// in delays, in the same order as the returned receivers. delays has to be
+	// at least as long as underlying.
+	SendBroadcastWithDelay(source int, size int, underlying []int, delays []time.Duration) ([]int, []time.Duration)
+}
+
+const ParametersHelp = `
+
+Delay is added to every delivered packet as a base plus a random jitter.
+
+  "delay_base_ms":      float64, optional, default 0;
+                        Fixed delay in milliseconds.
+  "delay_jitter_ms":    float64, optional, default 0;
+                        Scale of jitter in milliseconds.
+  "delay_jitter":       string, optional, default "uniform";
+                        Jitter distribution: "uniform" in [0, delay_jitter_ms),
+                        "normal" with standard deviation of delay_jitter_ms,
+                        or "exponential" with mean of delay_jitter_ms. Total
+                        delay is never below 0.
+`
+
+// Model is a base-plus-jitter delay distribution. The zero value adds no
+// delay.
+type Model struct {
+	Base         time.Duration
+	Jitter       time.Duration
+	Distribution string // "uniform", "normal" or "exponential"
+
+	random *random.Source
+}
+
+// Configure reads the parameters in ParametersHelp from conf.
+func (m *Model) Configure(conf *etcd.Node) (err error) {
+	if conf == nil {
+		return errors.New("delay: conf (*etcd.Node) is nil")
+	}
+
+	m.Distribution = "uniform"
+	var base, jitter float64
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "/delay_base_ms") {
+			base, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/delay_jitter_ms") {
+			jitter, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/delay_jitter") {
+			m.Distribution = node.Value
+		}
+	}
+
+	var errorParameters []string
+	if base < 0 {
+		errorParameters = append(errorParameters, "delay_base_ms")
+	}
+	if jitter < 0 {
+		errorParameters = append(errorParameters, "delay_jitter_ms")
+	}
+	switch m.Distribution {
+	case "uniform", "normal", "exponential":
+	default:
+		errorParameters = append(errorParameters, "delay_jitter")
+	}
+	if len(errorParameters) != 0 {
+		return fmt.Errorf("parameter(s) missing or invalid: %v", errorParameters)
+	}
+
+	m.Base = time.Duration(base * float64(time.Millisecond))
+	m.Jitter = time.Duration(jitter * float64(time.Millisecond))
+	m.random, err = random.Configure(conf)
+	return
+}
+
+// Sample returns a random delay of a packet from source to destination.
+func (m *Model) Sample(source int, destination int) time.Duration {
+	if m.Jitter == 0 {
+		return m.Base
+	}
+	r := m.random.Link("delay", source, destination)
+	var j float64
+	switch m.Distribution {
+	case "normal":
+		j = r.NormFloat64()
+	case "exponential":
+		j = r.ExpFloat64()
+	default:
+		j = r.Float64()
+	}
+	d := m.Base + time.Duration(j*float64(m.Jitter))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
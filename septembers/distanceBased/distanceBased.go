@@ -2,18 +2,27 @@ package distanceBased
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"math"
-	"math/rand"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/delay"
+	"github.com/squirrel-land/models/septembers/radio"
+	"github.com/squirrel-land/models/septembers/random"
 	"github.com/squirrel-land/squirrel"
 )
 
 type distanceBased struct {
 	positionManager    squirrel.PositionManager
 	noDeliveryDistance float64
+	radios             radio.PerNode
+	fading             radio.Fading
+	delay              delay.Model
+	random             *random.Source
 }
 
 func CreateSeptember() squirrel.September {
@@ -24,12 +33,13 @@ func (d *distanceBased) ParametersHelp() string {
 	return `DistanceBased is a september that delivers packets only based on distance
 between nodes. It applies a packet loss (d/D)^4 to each packet, where d is the
 distance between the two nodes, and D is the maximum communication range. It
-does not consider interference.
+does not consider interference. Optional fading scales the distance as if
+received power fell with d^4.
 
   "transmission_range": float64, required;
 												Maximum transmission range, i.e., the lowest distance
-												where packet delivery ratio will be zero.
-    `
+												where packet delivery ratio will be zero.` +
+		radio.PerNodeParametersHelp + radio.FadingParametersHelp + random.ParametersHelp + delay.ParametersHelp
 }
 
 func (d *distanceBased) Configure(conf *etcd.Node) (err error) {
@@ -49,20 +59,42 @@ func (d *distanceBased) Configure(conf *etcd.Node) (err error) {
 			break
 		}
 	}
-	if !found {
-		err = errors.New("transmission_range is missing from config")
+	if !found || !(d.noDeliveryDistance > 0) {
+		err = fmt.Errorf("parameter(s) missing or invalid: %v", []string{"transmission_range"})
+		return
+	}
+	if err = d.radios.Configure(conf); err != nil {
+		return
+	}
+	if err = d.fading.Configure(conf); err != nil {
+		return
+	}
+	if err = d.delay.Configure(conf); err != nil {
+		return
 	}
+	d.random, err = random.Configure(conf)
 	return
 }
 
 func (d *distanceBased) Initialize(positionManager squirrel.PositionManager) {
 	d.positionManager = positionManager
+	if err := d.radios.Initialize(positionManager, radio.Radio{TransmissionRange: d.noDeliveryDistance}); err != nil {
+		log.Fatalf("initializing DistanceBased error: %s", err.Error())
+	}
+	d.fading.Initialize(positionManager)
 }
 
 func (d *distanceBased) SendUnicast(source int, destination int, size int) bool {
 	return d.isToBeDelivered(source, destination)
 }
 
+func (d *distanceBased) SendUnicastWithDelay(source int, destination int, size int) (bool, time.Duration) {
+	if !d.isToBeDelivered(source, destination) {
+		return false, 0
+	}
+	return true, d.delay.Sample(source, destination)
+}
+
 func (d *distanceBased) SendBroadcast(source int, size int, underlying []int) []int {
 	count := 0
 	for _, i := range d.positionManager.Enabled() {
@@ -74,13 +106,23 @@ func (d *distanceBased) SendBroadcast(source int, size int, underlying []int) []
 	return underlying[:count]
 }
 
+func (d *distanceBased) SendBroadcastWithDelay(source int, size int, underlying []int, delays []time.Duration) ([]int, []time.Duration) {
+	delivered := d.SendBroadcast(source, size, underlying)
+	for i, dest := range delivered {
+		delays[i] = d.delay.Sample(source, dest)
+	}
+	return delivered, delays[:len(delivered)]
+}
+
 func (d *distanceBased) isToBeDelivered(id1 int, id2 int) bool {
 	if d.positionManager.IsEnabled(id1) && d.positionManager.IsEnabled(id2) {
-		dist := d.positionManager.Distance(id1, id2)
-		if dist < d.noDeliveryDistance*0.8 {
+		dist := d.radios.Distance(id1, id2)
+		dist = radio.EquivalentDistance(dist, d.fading.Gain(id1, id2), 4)
+		noDeliveryDistance := d.radios.LinkRange(id1, id2, 4)
+		if dist < noDeliveryDistance*0.8 {
 			return true
 		}
-		return rand.Float64() > math.Pow(dist/d.noDeliveryDistance, 4)
+		return d.random.Link("rx", id1, id2).Float64() > math.Pow(dist/noDeliveryDistance, 4)
 	} else {
 		return false
 	}
@@ -0,0 +1,438 @@
+package faultInjection
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/httpAccess"
+	"github.com/squirrel-land/models/septembers/clock"
+	"github.com/squirrel-land/models/septembers/nodeRef"
+	"github.com/squirrel-land/models/septembers/qos"
+	"github.com/squirrel-land/models/septembers/random"
+	"github.com/squirrel-land/models/septembers/wrapper"
+	"github.com/squirrel-land/squirrel"
+)
+
+// JSEvent is a fault that is in effect from Start to End, in seconds since the
+// schedule started. End of 0 means it never ends. Nodes are referred to by
+// index or hardware address.
+//
+// Type is one of:
+//
+//	"partition": nodes in different Groups can't talk to each other;
+//	"down":      Nodes can neither send nor receive;
+//	"degrade":   Links, in both directions, lose packets with probability Loss.
+type JSEvent struct {
+	Type   string
+	Start  float64
+	End    float64
+	Nodes  []string   `json:",omitempty"`
+	Groups [][]string `json:",omitempty"`
+	Links  [][]string `json:",omitempty"`
+	Loss   float64    `json:",omitempty"`
+}
+
+func (e *JSEvent) check() error {
+	if e == nil {
+		return errors.New("event is null")
+	}
+	switch e.Type {
+	case "partition":
+		if len(e.Groups) < 2 {
+			return errors.New("partition needs at least 2 groups")
+		}
+	case "down":
+		if len(e.Nodes) == 0 {
+			return errors.New("down needs nodes")
+		}
+	case "degrade":
+		if len(e.Links) == 0 {
+			return errors.New("degrade needs links")
+		}
+		for _, lk := range e.Links {
+			if len(lk) != 2 {
+				return errors.New("a link has to have 2 nodes")
+			}
+		}
+		if e.Loss < 0 || e.Loss > 1 {
+			return errors.New("loss has to be within [0, 1]")
+		}
+	default:
+		return fmt.Errorf("unknown event type %q", e.Type)
+	}
+	if e.Start < 0 || (e.End != 0 && e.End < e.Start) {
+		return errors.New("invalid start or end")
+	}
+
+	for i := range e.Nodes {
+		e.Nodes[i] = nodeRef.Normalize(e.Nodes[i])
+	}
+	for _, group := range e.Groups {
+		for i := range group {
+			group[i] = nodeRef.Normalize(group[i])
+		}
+	}
+	for _, lk := range e.Links {
+		lk[0], lk[1] = nodeRef.Normalize(lk[0]), nodeRef.Normalize(lk[1])
+	}
+	return nil
+}
+
+// refs returns all references to nodes in e.
+func (e *JSEvent) refs() []string {
+	refs := append([]string(nil), e.Nodes...)
+	for _, group := range e.Groups {
+		refs = append(refs, group...)
+	}
+	for _, lk := range e.Links {
+		refs = append(refs, lk...)
+	}
+	return refs
+}
+
+func (e *JSEvent) activeAt(t float64) bool {
+	return t >= e.Start && (e.End == 0 || t < e.End)
+}
+
+func contains(list []string, refs []string) bool {
+	for _, a := range list {
+		for _, b := range refs {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loss returns the probability that a packet from a node referred to by src
+// to one referred to by dst is lost because of e.
+func (e *JSEvent) loss(src []string, dst []string) float64 {
+	switch e.Type {
+	case "partition":
+		srcGroup, dstGroup := -1, -1
+		for i, group := range e.Groups {
+			if contains(group, src) {
+				srcGroup = i
+			}
+			if contains(group, dst) {
+				dstGroup = i
+			}
+		}
+		if srcGroup >= 0 && dstGroup >= 0 && srcGroup != dstGroup {
+			return 1
+		}
+	case "down":
+		if contains(e.Nodes, src) || contains(e.Nodes, dst) {
+			return 1
+		}
+	case "degrade":
+		for _, lk := range e.Links {
+			if (contains(src, lk[:1]) && contains(dst, lk[1:])) || (contains(src, lk[1:]) && contains(dst, lk[:1])) {
+				return e.Loss
+			}
+		}
+	}
+	return 0
+}
+
+type faultInjection struct {
+	factory         wrapper.Factory
+	inner           squirrel.September
+	positionManager squirrel.PositionManager
+	random          *random.Source
+	clock           clock.Clock
+
+	file   string
+	laddr  string
+	access *httpAccess.Access
+
+	configured []*JSEvent // from etcd
+
+	mu      sync.RWMutex
+	events  []*JSEvent
+	started time.Time
+}
+
+func CreateSeptember(factory wrapper.Factory) squirrel.September {
+	return &faultInjection{factory: factory, clock: clock.Real{}}
+}
+
+func (f *faultInjection) ParametersHelp() string {
+	return `FaultInjection wraps another september and, on top of its decisions, drops
+packets according to a schedule of faults: partitions, nodes going down and
+degraded links. Time is counted from when the september is initialized.
+` + wrapper.InnerParametersHelp + `  "events":             directory, optional;
+                        Events as JSON objects, e.g. "events/1":
+                        {"Type": "partition", "Start": 10, "End": 20,
+                         "Groups": [["0", "1"], ["2", "3"]]},
+                        {"Type": "down", "Start": 5, "Nodes": ["4"]},
+                        {"Type": "degrade", "Start": 0, "End": 30,
+                         "Links": [["1", "2"]], "Loss": 0.3}.
+                        Start and End are in seconds; End of 0 means forever.
+                        Nodes are node indexes or hardware addresses, and
+                        have to refer to existing nodes.
+  "file":               string, optional;
+                        A JSON file with a list of more events.
+  "laddr":              string, optional;
+                        TCP address to serve an HTTP API on: GET /events lists
+                        events and the current time; POST /events with a JSON
+                        list adds events, with Start and End relative to now;
+                        POST /clear removes all events; POST /restart reloads
+                        events from config and file and restarts the clock.
+                        If the host part is empty, only localhost is listened
+                        on unless "public" is true.` +
+		httpAccess.ParametersHelp + random.ParametersHelp + `    `
+}
+
+func (f *faultInjection) Configure(conf *etcd.Node) (err error) {
+	if conf == nil {
+		err = errors.New("FaultInjection: conf (*etcd.Node) is nil")
+		return
+	}
+
+	for _, node := range conf.Nodes {
+		if node.Dir && strings.HasSuffix(node.Key, "/events") {
+			for _, e := range node.Nodes {
+				var event JSEvent
+				if err = json.Unmarshal([]byte(e.Value), &event); err != nil {
+					return fmt.Errorf("parsing %s error: %v", e.Key, err)
+				}
+				if err = event.check(); err != nil {
+					return fmt.Errorf("%s: %v", e.Key, err)
+				}
+				f.configured = append(f.configured, &event)
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/file") {
+			f.file = node.Value
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/laddr") {
+			f.laddr = node.Value
+		}
+	}
+
+	if f.file != "" {
+		if _, err = loadFile(f.file); err != nil {
+			return
+		}
+	}
+
+	if f.laddr != "" {
+		if f.access, f.laddr, err = httpAccess.Configure(conf, f.laddr); err != nil {
+			return
+		}
+	}
+	if f.random, err = random.Configure(conf); err != nil {
+		return
+	}
+	f.inner, err = wrapper.ConfigureInner(conf, f.factory)
+	return
+}
+
+// scheduled returns events from config and file.
+func (f *faultInjection) scheduled() ([]*JSEvent, error) {
+	events := append([]*JSEvent(nil), f.configured...)
+	if f.file != "" {
+		fromFile, err := loadFile(f.file)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, fromFile...)
+	}
+	return events, nil
+}
+
+// checkRefs returns an error if any of events refers to no node.
+func (f *faultInjection) checkRefs(events []*JSEvent) error {
+	for _, e := range events {
+		if err := nodeRef.Check(f.positionManager, e.refs()...); err != nil {
+			return fmt.Errorf("%s event: %v", e.Type, err)
+		}
+	}
+	return nil
+}
+
+func loadFile(name string) (events []*JSEvent, err error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if err = json.NewDecoder(file).Decode(&events); err != nil {
+		return
+	}
+	for _, e := range events {
+		if err = e.check(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (f *faultInjection) Initialize(positionManager squirrel.PositionManager) {
+	f.positionManager = positionManager
+	f.inner.Initialize(positionManager)
+	events, err := f.scheduled()
+	if err != nil {
+		log.Fatalf("initializing FaultInjection error: %s", err.Error())
+	}
+	if err = f.checkRefs(events); err != nil {
+		log.Fatalf("initializing FaultInjection error: %s", err.Error())
+	}
+	f.restart(events)
+	if f.laddr != "" {
+		if err = f.access.Serve(f.laddr, "FaultInjection", f.bindMux()); err != nil {
+			log.Fatalf("initializing FaultInjection error: %s", err.Error())
+		}
+	}
+}
+
+func (f *faultInjection) restart(events []*JSEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = events
+	f.started = f.clock.Now()
+}
+
+// SetClock replaces the wall clock that times the schedule, and passes c on to
+// the inner september. It implements clock.User.
+func (f *faultInjection) SetClock(c clock.Clock) {
+	f.clock = c
+	wrapper.SetClock(f.inner, c)
+}
+
+func (f *faultInjection) now() float64 {
+	return f.clock.Now().Sub(f.started).Seconds()
+}
+
+// isToBeDropped tells whether the schedule drops a packet from source to
+// destination that the inner september would deliver.
+func (f *faultInjection) isToBeDropped(source int, destination int) bool {
+	src := nodeRef.Of(f.positionManager, source)
+	dst := nodeRef.Of(f.positionManager, destination)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	t := f.now()
+	for _, e := range f.events {
+		if e.activeAt(t) && f.random.Link("fault", source, destination).Float64() < e.loss(src, dst) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *faultInjection) SendUnicast(source int, destination int, size int) bool {
+	return f.inner.SendUnicast(source, destination, size) && !f.isToBeDropped(source, destination)
+}
+
+func (f *faultInjection) SendBroadcast(source int, size int, underlying []int) []int {
+	delivered, _ := f.broadcast(source, f.inner.SendBroadcast(source, size, underlying), nil)
+	return delivered
+}
+
+// broadcast drops receivers in delivered, along with their delays, that the
+// schedule cuts off from source.
+func (f *faultInjection) broadcast(source int, delivered []int, delays []time.Duration) ([]int, []time.Duration) {
+	return wrapper.Filter(delivered, delays, func(i int) bool { return !f.isToBeDropped(source, i) })
+}
+
+// SendUnicastWithDelay implements delay.September.
+func (f *faultInjection) SendUnicastWithDelay(source int, destination int, size int) (bool, time.Duration) {
+	delivered, d := wrapper.SendUnicastWithDelay(f.inner, source, destination, size)
+	return delivered && !f.isToBeDropped(source, destination), d
+}
+
+// SendBroadcastWithDelay implements delay.September.
+func (f *faultInjection) SendBroadcastWithDelay(source int, size int, underlying []int, delays []time.Duration) ([]int, []time.Duration) {
+	delivered, delays := wrapper.SendBroadcastWithDelay(f.inner, source, size, underlying, delays)
+	return f.broadcast(source, delivered, delays)
+}
+
+// SendUnicastWithCategory implements qos.September.
+func (f *faultInjection) SendUnicastWithCategory(source int, destination int, size int, ac qos.AccessCategory) (bool, time.Duration) {
+	delivered, d := wrapper.SendUnicastWithCategory(f.inner, source, destination, size, ac)
+	return delivered && !f.isToBeDropped(source, destination), d
+}
+
+// SendBroadcastWithCategory implements qos.September.
+func (f *faultInjection) SendBroadcastWithCategory(source int, size int, ac qos.AccessCategory, underlying []int, delays []time.Duration) ([]int, []time.Duration) {
+	delivered, delays := wrapper.SendBroadcastWithCategory(f.inner, source, size, ac, underlying, delays)
+	return f.broadcast(source, delivered, delays)
+}
+
+func (f *faultInjection) bindMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/events", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" {
+			var events []*JSEvent
+			if err := json.NewDecoder(req.Body).Decode(&events); err != nil {
+				http.Error(w, "json Decoding error", 500)
+				return
+			}
+			for _, e := range events {
+				if err := e.check(); err != nil {
+					http.Error(w, err.Error(), 400)
+					return
+				}
+			}
+			if err := f.checkRefs(events); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			f.mu.Lock()
+			t := f.now()
+			for _, e := range events {
+				e.Start += t
+				if e.End != 0 {
+					e.End += t
+				}
+				f.events = append(f.events, e)
+			}
+			f.mu.Unlock()
+			return
+		}
+
+		f.mu.RLock()
+		now, events := f.now(), append([]*JSEvent(nil), f.events...)
+		f.mu.RUnlock()
+		json.NewEncoder(w).Encode(struct {
+			Now    float64
+			Events []*JSEvent
+		}{now, events})
+	})
+	mux.HandleFunc("/clear", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			http.NotFound(w, req)
+			return
+		}
+		f.mu.Lock()
+		f.events = nil
+		f.mu.Unlock()
+	})
+	mux.HandleFunc("/restart", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			http.NotFound(w, req)
+			return
+		}
+		events, err := f.scheduled()
+		if err == nil {
+			err = f.checkRefs(events)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		f.restart(events)
+	})
+
+	return mux
+}
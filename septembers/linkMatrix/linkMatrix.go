@@ -0,0 +1,349 @@
+package linkMatrix
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/httpAccess"
+	"github.com/squirrel-land/models/septembers/nodeRef"
+	"github.com/squirrel-land/models/septembers/random"
+	"github.com/squirrel-land/squirrel"
+)
+
+// JSLink is the delivery probability from Source to Destination. Both are
+// either a node index or a hardware address.
+type JSLink struct {
+	Source      string
+	Destination string
+	Delivery    float64
+}
+
+type link struct {
+	src string
+	dst string
+}
+
+type linkMatrix struct {
+	positionManager squirrel.PositionManager
+	random          *random.Source
+
+	defaultDelivery float64
+	symmetric       bool
+	file            string
+	laddr           string
+	access          *httpAccess.Access
+
+	mu         sync.RWMutex
+	configured []*JSLink // from etcd
+	links      map[link]float64
+}
+
+func CreateSeptember() squirrel.September {
+	return &linkMatrix{}
+}
+
+func (l *linkMatrix) ParametersHelp() string {
+	return `LinkMatrix is a september that delivers packets with probabilities given
+per ordered pair of nodes, regardless of their positions. Nodes are referred to
+by index or by hardware address; references to no node, or to hardware
+addresses when the position manager can't look them up, are an error.
+
+  "default_delivery":   float64, optional, default 0;
+                        Delivery probability of pairs that are not listed.
+  "symmetric":          bool, optional, default true;
+                        Whether a link listed as A,B also applies to B,A when
+                        B,A is not listed itself.
+  "links":              directory, optional;
+                        Links as values of "source,destination,probability",
+                        e.g. "links/1": "0,1,0.9".
+  "file":               string, optional;
+                        A file with more links. If it ends with .json, it has
+                        a list of {"Source", "Destination", "Delivery"};
+                        otherwise it has one "source,destination,probability"
+                        per line, and lines starting with # are ignored. Links
+                        in the file override those from "links".
+  "laddr":              string, optional;
+                        TCP address to serve an HTTP API for updating links at
+                        runtime: GET /links lists them; POST /links with a
+                        JSON list adds or replaces them; POST /clear removes
+                        all; POST /reload reloads links from config and file.
+                        If the host part is empty, only localhost is listened
+                        on unless "public" is true.` +
+		httpAccess.ParametersHelp + random.ParametersHelp + `    `
+}
+
+func (l *linkMatrix) Configure(conf *etcd.Node) (err error) {
+	if conf == nil {
+		err = errors.New("LinkMatrix: conf (*etcd.Node) is nil")
+		return
+	}
+
+	l.symmetric = true
+	for _, node := range conf.Nodes {
+		if node.Dir && strings.HasSuffix(node.Key, "/links") {
+			for _, e := range node.Nodes {
+				var lk *JSLink
+				if lk, err = parseLink(e.Value); err != nil {
+					return
+				}
+				l.configured = append(l.configured, lk)
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/default_delivery") {
+			l.defaultDelivery, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/symmetric") {
+			l.symmetric, err = strconv.ParseBool(node.Value)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/file") {
+			l.file = node.Value
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/laddr") {
+			l.laddr = node.Value
+		}
+	}
+
+	if l.defaultDelivery < 0 || l.defaultDelivery > 1 {
+		return errors.New("default_delivery has to be within [0, 1]")
+	}
+	if l.laddr != "" {
+		if l.access, l.laddr, err = httpAccess.Configure(conf, l.laddr); err != nil {
+			return
+		}
+	}
+	if l.random, err = random.Configure(conf); err != nil {
+		return
+	}
+	return l.reload()
+}
+
+// reload rebuilds links from config and file.
+func (l *linkMatrix) reload() error {
+	links := make(map[link]float64)
+	for _, lk := range l.configured {
+		links[link{lk.Source, lk.Destination}] = lk.Delivery
+	}
+	if l.file != "" {
+		fromFile, err := loadFile(l.file)
+		if err != nil {
+			return err
+		}
+		for _, lk := range fromFile {
+			links[link{lk.Source, lk.Destination}] = lk.Delivery
+		}
+	}
+	if l.positionManager != nil {
+		// otherwise Initialize checks them
+		if err := l.checkRefs(links); err != nil {
+			return err
+		}
+	}
+
+	l.mu.Lock()
+	l.links = links
+	l.mu.Unlock()
+	return nil
+}
+
+// checkRefs returns an error if any of links refers to no node.
+func (l *linkMatrix) checkRefs(links map[link]float64) error {
+	for lk := range links {
+		if err := nodeRef.Check(l.positionManager, lk.src, lk.dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkLink(lk *JSLink) error {
+	if lk == nil {
+		return errors.New("link is null")
+	}
+	lk.Source, lk.Destination = nodeRef.Normalize(lk.Source), nodeRef.Normalize(lk.Destination)
+	if lk.Source == "" || lk.Destination == "" {
+		return errors.New("link source or destination is empty")
+	}
+	if lk.Delivery < 0 || lk.Delivery > 1 {
+		return fmt.Errorf("delivery probability of %s,%s is not within [0, 1]", lk.Source, lk.Destination)
+	}
+	return nil
+}
+
+func parseLink(s string) (*JSLink, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("invalid link %q; expecting source,destination,probability", s)
+	}
+	p, err := strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+	if err != nil {
+		return nil, err
+	}
+	lk := &JSLink{Source: fields[0], Destination: fields[1], Delivery: p}
+	return lk, checkLink(lk)
+}
+
+func loadFile(name string) (links []*JSLink, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if filepath.Ext(name) == ".json" {
+		if err = json.NewDecoder(f).Decode(&links); err != nil {
+			return
+		}
+		for _, lk := range links {
+			if err = checkLink(lk); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var lk *JSLink
+		if lk, err = parseLink(line); err != nil {
+			return
+		}
+		links = append(links, lk)
+	}
+	err = scanner.Err()
+	return
+}
+
+func (l *linkMatrix) Initialize(positionManager squirrel.PositionManager) {
+	l.positionManager = positionManager
+	l.mu.RLock()
+	err := l.checkRefs(l.links)
+	l.mu.RUnlock()
+	if err != nil {
+		log.Fatalf("initializing LinkMatrix error: %s", err.Error())
+	}
+	if l.laddr != "" {
+		if err = l.access.Serve(l.laddr, "LinkMatrix", l.bindMux()); err != nil {
+			log.Fatalf("initializing LinkMatrix error: %s", err.Error())
+		}
+	}
+}
+
+func (l *linkMatrix) delivery(src int, dst int) float64 {
+	srcRefs, dstRefs := nodeRef.Of(l.positionManager, src), nodeRef.Of(l.positionManager, dst)
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, s := range srcRefs {
+		for _, d := range dstRefs {
+			if p, ok := l.links[link{s, d}]; ok {
+				return p
+			}
+		}
+	}
+	if l.symmetric {
+		for _, s := range srcRefs {
+			for _, d := range dstRefs {
+				if p, ok := l.links[link{d, s}]; ok {
+					return p
+				}
+			}
+		}
+	}
+	return l.defaultDelivery
+}
+
+func (l *linkMatrix) SendUnicast(source int, destination int, size int) bool {
+	return l.isToBeDelivered(source, destination)
+}
+
+func (l *linkMatrix) SendBroadcast(source int, size int, underlying []int) []int {
+	count := 0
+	for _, i := range l.positionManager.Enabled() {
+		if i != source && l.isToBeDelivered(source, i) {
+			underlying[count] = i
+			count++
+		}
+	}
+	return underlying[:count]
+}
+
+func (l *linkMatrix) isToBeDelivered(id1 int, id2 int) bool {
+	if l.positionManager.IsEnabled(id1) && l.positionManager.IsEnabled(id2) {
+		return l.random.Link("rx", id1, id2).Float64() < l.delivery(id1, id2)
+	}
+	return false
+}
+
+func (l *linkMatrix) bindMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/links", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" {
+			var links []*JSLink
+			if err := json.NewDecoder(req.Body).Decode(&links); err != nil {
+				http.Error(w, "json Decoding error", 500)
+				return
+			}
+			for _, lk := range links {
+				if err := checkLink(lk); err != nil {
+					http.Error(w, err.Error(), 400)
+					return
+				}
+				if err := nodeRef.Check(l.positionManager, lk.Source, lk.Destination); err != nil {
+					http.Error(w, err.Error(), 400)
+					return
+				}
+			}
+			l.mu.Lock()
+			for _, lk := range links {
+				l.links[link{lk.Source, lk.Destination}] = lk.Delivery
+			}
+			l.mu.Unlock()
+			return
+		}
+
+		l.mu.RLock()
+		ret := make([]*JSLink, 0, len(l.links))
+		for lk, p := range l.links {
+			ret = append(ret, &JSLink{Source: lk.src, Destination: lk.dst, Delivery: p})
+		}
+		l.mu.RUnlock()
+		json.NewEncoder(w).Encode(ret)
+	})
+	mux.HandleFunc("/clear", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			http.NotFound(w, req)
+			return
+		}
+		l.mu.Lock()
+		l.links = make(map[link]float64)
+		l.mu.Unlock()
+	})
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			http.NotFound(w, req)
+			return
+		}
+		if err := l.reload(); err != nil {
+			http.Error(w, err.Error(), 500)
+		}
+	})
+
+	return mux
+}
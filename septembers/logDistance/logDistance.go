@@ -0,0 +1,144 @@
+package logDistance
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/radio"
+	"github.com/squirrel-land/models/septembers/random"
+	"github.com/squirrel-land/squirrel"
+)
+
+type logDistance struct {
+	positionManager squirrel.PositionManager
+
+	txPower  float64 // dBm
+	pathLoss radio.LogDistance
+	receiver radio.Receiver
+	fading   radio.Fading
+	random   *random.Source
+}
+
+func CreateSeptember() squirrel.September {
+	return &logDistance{}
+}
+
+func (l *logDistance) ParametersHelp() string {
+	return `LogDistance is a september that computes received power with the
+log-distance path loss model and log-normal shadowing, and delivers packets
+based on a receiver sensitivity curve. It does not consider interference.
+
+  "tx_power_dbm":       float64, required;
+                        Transmission power in dBm.
+  "reference_loss_db":  float64, required;
+                        Path loss in dB at reference_distance.
+  "reference_distance": float64, optional, default 1;
+                        Distance d0 where reference_loss_db is measured, in
+                        the same unit as node positions.
+  "path_loss_exponent": float64, required;
+                        Path loss exponent; 2 for free space, normally 2 to 6.
+  "shadowing_sigma_db": float64, optional, default 0;
+                        Standard deviation of log-normal shadowing in dB.` +
+		radio.ReceiverParametersHelp + radio.FadingParametersHelp + random.ParametersHelp
+}
+
+func (l *logDistance) Configure(conf *etcd.Node) (err error) {
+	if conf == nil {
+		err = errors.New("LogDistance: conf (*etcd.Node) is nil")
+		return
+	}
+
+	l.pathLoss.ReferenceDistance = 1
+	var txPowerFound, referenceLossFound bool
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "tx_power_dbm") {
+			l.txPower, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+			txPowerFound = true
+		} else if !node.Dir && strings.HasSuffix(node.Key, "reference_loss_db") {
+			l.pathLoss.ReferenceLoss, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+			referenceLossFound = true
+		} else if !node.Dir && strings.HasSuffix(node.Key, "reference_distance") {
+			l.pathLoss.ReferenceDistance, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "path_loss_exponent") {
+			l.pathLoss.Exponent, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "shadowing_sigma_db") {
+			l.pathLoss.Sigma, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	var errorParameters []string
+	if !txPowerFound {
+		errorParameters = append(errorParameters, "tx_power_dbm")
+	}
+	if !referenceLossFound {
+		errorParameters = append(errorParameters, "reference_loss_db")
+	}
+	if l.pathLoss.ReferenceDistance <= 0 {
+		errorParameters = append(errorParameters, "reference_distance")
+	}
+	if l.pathLoss.Exponent <= 0 {
+		errorParameters = append(errorParameters, "path_loss_exponent")
+	}
+	if l.pathLoss.Sigma < 0 {
+		errorParameters = append(errorParameters, "shadowing_sigma_db")
+	}
+	if len(errorParameters) != 0 {
+		err = fmt.Errorf("parameter(s) missing or invalid: %v", errorParameters)
+		return
+	}
+
+	if err = l.receiver.Configure(conf); err != nil {
+		return
+	}
+	if err = l.fading.Configure(conf); err != nil {
+		return
+	}
+	l.random, err = random.Configure(conf)
+	return
+}
+
+func (l *logDistance) Initialize(positionManager squirrel.PositionManager) {
+	l.positionManager = positionManager
+	l.fading.Initialize(positionManager)
+}
+
+func (l *logDistance) SendUnicast(source int, destination int, size int) bool {
+	return l.isToBeDelivered(source, destination, size)
+}
+
+func (l *logDistance) SendBroadcast(source int, size int, underlying []int) []int {
+	count := 0
+	for _, i := range l.positionManager.Enabled() {
+		if i != source && l.isToBeDelivered(source, i, size) {
+			underlying[count] = i
+			count++
+		}
+	}
+	return underlying[:count]
+}
+
+func (l *logDistance) isToBeDelivered(id1 int, id2 int, size int) bool {
+	if !(l.positionManager.IsEnabled(id1) && l.positionManager.IsEnabled(id2)) {
+		return false
+	}
+	rxPower := l.txPower - l.pathLoss.Loss(l.positionManager.Distance(id1, id2), l.random.Link("shadowing", id1, id2)) + l.fading.GainDB(id1, id2)
+	return l.random.Link("rx", id1, id2).Float64() < l.receiver.DeliveryProbability(rxPower, size)
+}
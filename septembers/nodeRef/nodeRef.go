@@ -0,0 +1,69 @@
+package nodeRef
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// addressLookup is implemented by position managers that can tell the
+// hardware address of a node by its index.
+type addressLookup interface {
+	Addr(index int) (string, error)
+}
+
+// Normalize turns a reference to a node, i.e. an index or a hardware address,
+// as given in config into the form returned by Of.
+func Normalize(ref string) string {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	if index, err := strconv.Atoi(ref); err == nil {
+		return strconv.Itoa(index)
+	}
+	return ref
+}
+
+// Of returns the references that node index can be referred to by: its index
+// and, if the position manager knows it, its hardware address.
+func Of(positionManager squirrel.PositionManager, index int) []string {
+	ret := []string{strconv.Itoa(index)}
+	if lookup, ok := positionManager.(addressLookup); ok {
+		if addr, err := lookup.Addr(index); err == nil && addr != "" {
+			ret = append(ret, Normalize(addr))
+		}
+	}
+	return ret
+}
+
+// Check returns an error if any of refs, as returned by Normalize, refers to
+// no node. Hardware addresses can only be resolved if the position manager
+// implements Addr(int) (string, error); otherwise they are an error too, rather
+// than a setting that silently never applies.
+func Check(positionManager squirrel.PositionManager, refs ...string) error {
+	var addrs map[string]bool
+	for _, ref := range refs {
+		if index, err := strconv.Atoi(ref); err == nil {
+			if index < 0 || index >= positionManager.Capacity() {
+				return fmt.Errorf("node %s does not exist; there are %d nodes", ref, positionManager.Capacity())
+			}
+			continue
+		}
+		if addrs == nil {
+			lookup, ok := positionManager.(addressLookup)
+			if !ok {
+				return fmt.Errorf("node %s: the position manager can't look up hardware addresses; refer to nodes by index", ref)
+			}
+			addrs = make(map[string]bool)
+			for i := 0; i < positionManager.Capacity(); i++ {
+				if addr, err := lookup.Addr(i); err == nil && addr != "" {
+					addrs[Normalize(addr)] = true
+				}
+			}
+		}
+		if !addrs[ref] {
+			return fmt.Errorf("node %s matches no node", ref)
+		}
+	}
+	return nil
+}
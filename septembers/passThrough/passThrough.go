@@ -1,12 +1,17 @@
 package passThrough
 
 import (
+	"time"
+
 	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/delay"
+	"github.com/squirrel-land/models/septembers/random"
 	"github.com/squirrel-land/squirrel"
 )
 
 type passThrough struct {
 	positionManager squirrel.PositionManager
+	delay           delay.Model
 }
 
 func CreateSeptember() squirrel.September {
@@ -14,11 +19,15 @@ func CreateSeptember() squirrel.September {
 }
 
 func (p *passThrough) ParametersHelp() string {
-	return `PassThrough delivers every packet sent into squirrel as long as the src and dst are valid.`
+	return `PassThrough delivers every packet sent into squirrel as long as the src and dst are valid.` +
+		delay.ParametersHelp + random.ParametersHelp
 }
 
 func (p *passThrough) Configure(conf *etcd.Node) (err error) {
-	return nil
+	if conf == nil {
+		return nil
+	}
+	return p.delay.Configure(conf)
 }
 
 func (p *passThrough) Initialize(positionManager squirrel.PositionManager) {
@@ -29,6 +38,13 @@ func (p *passThrough) SendUnicast(source int, destination int, size int) bool {
 	return p.isToBeDelivered(source, destination)
 }
 
+func (p *passThrough) SendUnicastWithDelay(source int, destination int, size int) (bool, time.Duration) {
+	if !p.isToBeDelivered(source, destination) {
+		return false, 0
+	}
+	return true, p.delay.Sample(source, destination)
+}
+
 func (p *passThrough) SendBroadcast(source int, size int, underlying []int) []int {
 	count := 0
 	for _, i := range p.positionManager.Enabled() {
@@ -40,6 +56,14 @@ func (p *passThrough) SendBroadcast(source int, size int, underlying []int) []in
 	return underlying[:count]
 }
 
+func (p *passThrough) SendBroadcastWithDelay(source int, size int, underlying []int, delays []time.Duration) ([]int, []time.Duration) {
+	delivered := p.SendBroadcast(source, size, underlying)
+	for i, dest := range delivered {
+		delays[i] = p.delay.Sample(source, dest)
+	}
+	return delivered, delays[:len(delivered)]
+}
+
 func (p *passThrough) isToBeDelivered(id1 int, id2 int) bool {
 	if p.positionManager.IsEnabled(id1) && p.positionManager.IsEnabled(id2) {
 		return true
@@ -0,0 +1,224 @@
+package passThrough
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/nodeRef"
+	"github.com/squirrel-land/squirrel"
+)
+
+// JSLimits are the link capacities of a node. Rates are in Mbps; 0 means
+// unlimited.
+type JSLimits struct {
+	UplinkMbps   float64
+	DownlinkMbps float64
+	BufferBytes  int
+}
+
+func (l *JSLimits) check() error {
+	if l.UplinkMbps < 0 || l.DownlinkMbps < 0 {
+		return errors.New("rates can't be negative")
+	}
+	if l.BufferBytes <= 0 {
+		return errors.New("buffer has to be positive")
+	}
+	return nil
+}
+
+// queue is one direction of a node's link. Bytes in it drain at rate, and are
+// computed lazily when a packet arrives.
+type queue struct {
+	mu      sync.Mutex
+	rate    float64 // bytes per second; 0 means unlimited
+	size    float64 // bytes
+	backlog float64 // bytes
+	last    time.Time
+}
+
+func newQueue(mbps float64, size int) *queue {
+	return &queue{rate: mbps * 1000 * 1000 / 8, size: float64(size)}
+}
+
+// in puts a packet of bytes into the queue, and tells whether there was room
+// for it.
+func (q *queue) in(bytes int) bool {
+	if q.rate == 0 {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	if !q.last.IsZero() {
+		q.backlog -= now.Sub(q.last).Seconds() * q.rate
+		if q.backlog < 0 {
+			q.backlog = 0
+		}
+	}
+	q.last = now
+	if q.backlog+float64(bytes) > q.size {
+		return false
+	}
+	q.backlog += float64(bytes)
+	return true
+}
+
+type nodeQueues struct {
+	uplink   *queue
+	downlink *queue
+}
+
+type rateLimited struct {
+	positionManager squirrel.PositionManager
+
+	defaults  JSLimits
+	overrides map[string]JSLimits // by node reference
+
+	mu     sync.Mutex
+	queues []*nodeQueues
+}
+
+func CreateRateLimitedSeptember() squirrel.September {
+	return &rateLimited{}
+}
+
+func (r *rateLimited) ParametersHelp() string {
+	return `RateLimitedPassThrough delivers packets between any valid src and dst like
+PassThrough, but each node has a limited uplink and downlink with a buffer.
+A packet that doesn't fit into the sender's uplink buffer or the receiver's
+downlink buffer is dropped; buffers drain at the link's rate.
+
+  "uplink_mbps":        float64, optional, default 0;
+                        Uplink rate of each node in Mbps; 0 means unlimited.
+  "downlink_mbps":      float64, optional, default 0;
+                        Downlink rate of each node in Mbps; 0 means unlimited.
+  "buffer_bytes":       int, optional, default 65536;
+                        Buffer size of each link direction in bytes.
+  "nodes":              directory, optional;
+                        Limits for specific nodes, keyed by node index or
+                        hardware address, e.g. "nodes/3":
+                        {"UplinkMbps": 1, "DownlinkMbps": 10,
+                         "BufferBytes": 16384}.
+    `
+}
+
+func (r *rateLimited) Configure(conf *etcd.Node) (err error) {
+	if conf == nil {
+		err = errors.New("RateLimitedPassThrough: conf (*etcd.Node) is nil")
+		return
+	}
+
+	r.defaults.BufferBytes = 65536
+	r.overrides = make(map[string]JSLimits)
+	var nodes *etcd.Node
+	for _, node := range conf.Nodes {
+		if node.Dir && strings.HasSuffix(node.Key, "/nodes") {
+			nodes = node
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/uplink_mbps") {
+			r.defaults.UplinkMbps, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/downlink_mbps") {
+			r.defaults.DownlinkMbps, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/buffer_bytes") {
+			r.defaults.BufferBytes, err = strconv.Atoi(node.Value)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	var errorParameters []string
+	if r.defaults.UplinkMbps < 0 {
+		errorParameters = append(errorParameters, "uplink_mbps")
+	}
+	if r.defaults.DownlinkMbps < 0 {
+		errorParameters = append(errorParameters, "downlink_mbps")
+	}
+	if r.defaults.BufferBytes <= 0 {
+		errorParameters = append(errorParameters, "buffer_bytes")
+	}
+	if len(errorParameters) != 0 {
+		err = fmt.Errorf("parameter(s) missing or invalid: %v", errorParameters)
+		return
+	}
+
+	if nodes != nil {
+		for _, node := range nodes.Nodes {
+			// fields not given fall back to defaults
+			limits := r.defaults
+			if err = json.Unmarshal([]byte(node.Value), &limits); err != nil {
+				return fmt.Errorf("parsing %s error: %v", node.Key, err)
+			}
+			if err = limits.check(); err != nil {
+				return fmt.Errorf("%s: %v", node.Key, err)
+			}
+			ref := nodeRef.Normalize(node.Key[strings.LastIndex(node.Key, "/")+1:])
+			r.overrides[ref] = limits
+		}
+	}
+	return nil
+}
+
+func (r *rateLimited) Initialize(positionManager squirrel.PositionManager) {
+	r.positionManager = positionManager
+	for ref := range r.overrides {
+		if err := nodeRef.Check(positionManager, ref); err != nil {
+			log.Fatalf("initializing RateLimitedPassThrough error: nodes: %s", err.Error())
+		}
+	}
+	r.queues = make([]*nodeQueues, positionManager.Capacity())
+}
+
+// queuesOf returns queues of node index, creating them on first use so that
+// limits can be looked up by hardware address once the node has one.
+func (r *rateLimited) queuesOf(index int) *nodeQueues {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.queues[index] == nil {
+		limits := r.defaults
+		for _, ref := range nodeRef.Of(r.positionManager, index) {
+			if l, ok := r.overrides[ref]; ok {
+				limits = l
+				break
+			}
+		}
+		r.queues[index] = &nodeQueues{
+			uplink:   newQueue(limits.UplinkMbps, limits.BufferBytes),
+			downlink: newQueue(limits.DownlinkMbps, limits.BufferBytes),
+		}
+	}
+	return r.queues[index]
+}
+
+func (r *rateLimited) SendUnicast(source int, destination int, size int) bool {
+	if !(r.positionManager.IsEnabled(source) && r.positionManager.IsEnabled(destination)) {
+		return false
+	}
+	return r.queuesOf(source).uplink.in(size) && r.queuesOf(destination).downlink.in(size)
+}
+
+func (r *rateLimited) SendBroadcast(source int, size int, underlying []int) []int {
+	if !r.positionManager.IsEnabled(source) || !r.queuesOf(source).uplink.in(size) {
+		return underlying[:0]
+	}
+	count := 0
+	for _, i := range r.positionManager.Enabled() {
+		if i != source && r.queuesOf(i).downlink.in(size) {
+			underlying[count] = i
+			count++
+		}
+	}
+	return underlying[:count]
+}
@@ -0,0 +1,149 @@
+package qos
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// AccessCategory is an 802.11e EDCA access category, lowest priority first.
+type AccessCategory int
+
+const (
+	Background AccessCategory = iota
+	BestEffort
+	Video
+	Voice
+)
+
+// Categories has every access category, lowest priority first.
+var Categories = []AccessCategory{Background, BestEffort, Video, Voice}
+
+var names = []string{"background", "best_effort", "video", "voice"}
+
+// Valid tells whether ac is one of Categories.
+func (ac AccessCategory) Valid() bool {
+	return ac >= Background && ac <= Voice
+}
+
+func (ac AccessCategory) String() string {
+	if !ac.Valid() {
+		return "AccessCategory(" + strconv.Itoa(int(ac)) + ")"
+	}
+	return names[ac]
+}
+
+// Parse returns the access category named name, as String returns it.
+func Parse(name string) (AccessCategory, error) {
+	for i, n := range names {
+		if n == name {
+			return AccessCategory(i), nil
+		}
+	}
+	return 0, errors.New("qos: unknown access category " + name)
+}
+
+// FromDSCP maps a DSCP value to an access category as RFC 8325 does: CS1 and
+// LE are background; EF, VOICE-ADMIT and CS6 are voice; CS3 to CS5 and AF3x,
+// AF4x are video; the rest, CS7 included, are best effort.
+func FromDSCP(dscp int) AccessCategory {
+	switch {
+	case dscp == 8 || dscp == 1: // CS1, LE
+		return Background
+	case dscp == 46 || dscp == 44 || dscp == 48: // EF, VOICE-ADMIT, CS6
+		return Voice
+	case dscp >= 24 && dscp <= 40: // CS3 to CS5, AF3x, AF4x
+		return Video
+	}
+	return BestEffort
+}
+
+// September is a squirrel.September that takes the access category of each
+// packet. Emulators that don't know about it keep calling SendUnicast and
+// SendBroadcast, which classify packets by the "qos_classifier" parameter.
+type September interface {
+	squirrel.September
+
+	// SendUnicastWithCategory is SendUnicast of a packet in category ac. It
+	// also returns the delivery delay, as delay.September does. Packets of an
+	// invalid category are best effort.
+	SendUnicastWithCategory(source int, destination int, size int, ac AccessCategory) (bool, time.Duration)
+
+	// SendBroadcastWithCategory is SendBroadcast of a packet in category ac.
+	// It also returns delivery delays, as delay.September does. Packets of an
+	// invalid category are best effort.
+	SendBroadcastWithCategory(source int, size int, ac AccessCategory, underlying []int, delays []time.Duration) ([]int, []time.Duration)
+}
+
+const ParametersHelp = `
+  "qos_classifier":     string, optional, default "none";
+                        How packets sent without an access category are
+                        classified: "none" makes them all best effort; "size"
+                        makes packets up to qos_voice_bytes voice, those up
+                        to qos_video_bytes video, and the rest best effort.
+  "qos_voice_bytes":    int, optional, default 200;
+  "qos_video_bytes":    int, optional, default 0;
+                        Not negative; qos_video_bytes of 0 makes no packets
+                        video, otherwise it has to be at least
+                        qos_voice_bytes.
+`
+
+// Classifier picks access categories of packets by their size. The zero value
+// makes every packet best effort.
+type Classifier struct {
+	BySize     bool
+	VoiceBytes int
+	VideoBytes int
+}
+
+// Configure reads the parameters in ParametersHelp from conf.
+func (c *Classifier) Configure(conf *etcd.Node) (err error) {
+	if conf == nil {
+		return errors.New("qos: conf (*etcd.Node) is nil")
+	}
+	c.BySize, c.VoiceBytes, c.VideoBytes = false, 200, 0
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "/qos_classifier") {
+			switch node.Value {
+			case "none":
+				c.BySize = false
+			case "size":
+				c.BySize = true
+			default:
+				return errors.New("qos: unknown qos_classifier")
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/qos_voice_bytes") {
+			if c.VoiceBytes, err = strconv.Atoi(node.Value); err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "/qos_video_bytes") {
+			if c.VideoBytes, err = strconv.Atoi(node.Value); err != nil {
+				return
+			}
+		}
+	}
+	if c.VoiceBytes < 0 || c.VideoBytes < 0 {
+		return errors.New("qos: qos_voice_bytes and qos_video_bytes can't be negative")
+	}
+	if c.VideoBytes != 0 && c.VideoBytes < c.VoiceBytes {
+		return errors.New("qos: qos_video_bytes is less than qos_voice_bytes")
+	}
+	return
+}
+
+// Classify returns the access category of a packet of size bytes.
+func (c *Classifier) Classify(size int) AccessCategory {
+	switch {
+	case !c.BySize:
+		return BestEffort
+	case size <= c.VoiceBytes:
+		return Voice
+	case size <= c.VideoBytes:
+		return Video
+	}
+	return BestEffort
+}
@@ -0,0 +1,271 @@
+package radio
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/clock"
+	"github.com/squirrel-land/models/septembers/random"
+	"github.com/squirrel-land/squirrel"
+)
+
+const FadingParametersHelp = `
+  "fading":             string, optional, default "none";
+                        Small-scale fading applied to each packet; one of
+                        "none", "rayleigh", "rician" or "nakagami".
+  "rician_k":           float64, optional, default 0;
+                        Ratio between line-of-sight and scattered power for
+                        Rician fading. 0 is the same as Rayleigh.
+  "nakagami_m":         float64, optional, default 1;
+                        Shape of Nakagami-m fading, at least 0.5. 1 is the
+                        same as Rayleigh. It is rounded to the nearest multiple
+                        of 0.5 when fading_correlated is true.
+  "fading_correlated":  bool, optional, default false;
+                        Correlate fading of consecutive packets on a link in
+                        time according to Clarke's model, based on how fast
+                        the nodes move. Requires frequency_mhz.
+  "frequency_mhz":      float64;
+                        Carrier frequency in MHz.
+  "units_per_meter":    float64, optional, default 1;
+                        Number of position units in one meter.
+`
+
+type fadingState struct {
+	components []float64 // zero mean, unit variance Gaussians
+	last       time.Time
+	p1, p2     squirrel.Position
+}
+
+// Fading draws small-scale fading power gains for links. Gains are linear and
+// have a mean of 1. The zero value is ready to use and doesn't fade.
+type Fading struct {
+	kind          string
+	ricianK       float64
+	nakagamiM     float64
+	correlated    bool
+	wavelength    float64 // meters
+	unitsPerMeter float64
+	random        *random.Source
+	clock         clock.Clock // times correlated fading; nil is the wall clock
+
+	positionManager squirrel.PositionManager
+	mu              sync.Mutex
+	links           map[[2]int]*fadingState
+}
+
+// Configure reads fading parameters (see FadingParametersHelp) from conf.
+func (f *Fading) Configure(conf *etcd.Node) (err error) {
+	f.kind = "none"
+	f.nakagamiM = 1
+	f.unitsPerMeter = 1
+	var frequency float64
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "/fading") {
+			f.kind = node.Value
+		} else if !node.Dir && strings.HasSuffix(node.Key, "rician_k") {
+			f.ricianK, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "nakagami_m") {
+			f.nakagamiM, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "fading_correlated") {
+			f.correlated, err = strconv.ParseBool(node.Value)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "frequency_mhz") {
+			frequency, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "units_per_meter") {
+			f.unitsPerMeter, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	switch f.kind {
+	case "none", "rayleigh":
+	case "rician":
+		if f.ricianK < 0 {
+			return errors.New("rician_k has to be at least 0")
+		}
+	case "nakagami":
+		if f.nakagamiM < 0.5 {
+			return errors.New("nakagami_m has to be at least 0.5")
+		}
+	default:
+		return errors.New("unknown fading")
+	}
+	if f.unitsPerMeter <= 0 {
+		return errors.New("units_per_meter has to be greater than 0")
+	}
+	if f.correlated {
+		if frequency <= 0 {
+			return errors.New("frequency_mhz is required by fading_correlated")
+		}
+		f.wavelength = Wavelength(frequency)
+	}
+	f.random, err = random.Configure(conf)
+	return
+}
+
+func (f *Fading) Initialize(positionManager squirrel.PositionManager) {
+	f.positionManager = positionManager
+	f.links = make(map[[2]int]*fadingState)
+}
+
+// SetClock replaces the wall clock that correlated fading is timed with. It
+// implements clock.User.
+func (f *Fading) SetClock(c clock.Clock) {
+	f.clock = c
+}
+
+// Enabled tells whether f does anything at all.
+func (f *Fading) Enabled() bool {
+	return f.kind != "" && f.kind != "none"
+}
+
+// GainDB returns Gain in dB.
+func (f *Fading) GainDB(id1 int, id2 int) float64 {
+	if !f.Enabled() {
+		return 0
+	}
+	return 10 * math.Log10(f.Gain(id1, id2))
+}
+
+// Gain returns the power gain of the next packet on the link between id1 and
+// id2. Links are symmetric.
+func (f *Fading) Gain(id1 int, id2 int) float64 {
+	if !f.Enabled() {
+		return 1
+	}
+	if id1 > id2 {
+		id1, id2 = id2, id1
+	}
+	r := f.random.Link("fading", id1, id2)
+	if !f.correlated {
+		switch f.kind {
+		case "rayleigh":
+			return r.ExpFloat64()
+		case "rician":
+			return f.rician(r.NormFloat64(), r.NormFloat64())
+		case "nakagami":
+			return gamma(r, f.nakagamiM) / f.nakagamiM
+		}
+	}
+
+	p1, err := f.positionManager.Get(id1)
+	if err != nil {
+		return 1
+	}
+	p2, err := f.positionManager.Get(id2)
+	if err != nil {
+		return 1
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	if f.clock != nil {
+		now = f.clock.Now()
+	}
+	state, ok := f.links[[2]int{id1, id2}]
+	if !ok {
+		state = &fadingState{components: make([]float64, f.numComponents())}
+		for i := range state.components {
+			state.components[i] = r.NormFloat64()
+		}
+		f.links[[2]int{id1, id2}] = state
+	} else {
+		// Clarke's model: correlation between samples dt apart is J0(2*pi*fd*dt)
+		// where fd = v/lambda is the maximum Doppler shift.
+		dt := now.Sub(state.last).Seconds()
+		moved := (distance(&state.p1, &p1) + distance(&state.p2, &p2)) / f.unitsPerMeter
+		rho := 0.
+		if dt > 0 {
+			fd := moved / dt / f.wavelength
+			rho = math.Max(0, math.J0(2*math.Pi*fd*dt))
+		} else {
+			rho = 1
+		}
+		innovation := math.Sqrt(1 - rho*rho)
+		for i := range state.components {
+			state.components[i] = rho*state.components[i] + innovation*r.NormFloat64()
+		}
+	}
+	state.last, state.p1, state.p2 = now, p1, p2
+
+	switch f.kind {
+	case "rician":
+		return f.rician(state.components[0], state.components[1])
+	case "nakagami":
+		sum := 0.
+		for _, x := range state.components {
+			sum += x * x
+		}
+		return sum / float64(len(state.components))
+	}
+	return (state.components[0]*state.components[0] + state.components[1]*state.components[1]) / 2
+}
+
+// numComponents returns how many Gaussians make up the fading process. A sum
+// of 2m squared Gaussians is Nakagami-m distributed in power.
+func (f *Fading) numComponents() int {
+	if f.kind == "nakagami" {
+		return int(math.Max(1, math.Floor(2*f.nakagamiM+.5)))
+	}
+	return 2
+}
+
+func (f *Fading) rician(x float64, y float64) float64 {
+	los := math.Sqrt(f.ricianK / (f.ricianK + 1))
+	sigma := math.Sqrt(1 / (2 * (f.ricianK + 1)))
+	i := los + sigma*x
+	q := sigma * y
+	return i*i + q*q
+}
+
+// EquivalentDistance turns a power gain into a change of distance, for models
+// where received power falls with distance^exponent. It lets fading be put on
+// top of models that don't compute received power.
+func EquivalentDistance(dist float64, gain float64, exponent float64) float64 {
+	return dist * math.Pow(gain, -1/exponent)
+}
+
+func distance(p1 *squirrel.Position, p2 *squirrel.Position) float64 {
+	dx, dy, dh := p1.X-p2.X, p1.Y-p2.Y, p1.Height-p2.Height
+	return math.Sqrt(dx*dx + dy*dy + dh*dh)
+}
+
+// gamma draws from Gamma(shape, 1) using Marsaglia and Tsang's method.
+func gamma(r *random.Rand, shape float64) float64 {
+	if shape < 1 {
+		return gamma(r, shape+1) * math.Pow(r.Float64(), 1/shape)
+	}
+	d := shape - 1./3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := r.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := r.Float64()
+		if math.Log(u) < x*x/2+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
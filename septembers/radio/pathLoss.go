@@ -0,0 +1,76 @@
+package radio
+
+import (
+	"math"
+
+	"github.com/squirrel-land/models/septembers/random"
+)
+
+// LogDistance is the log-distance path loss model with log-normal shadowing:
+//
+//	PL(d) = ReferenceLoss + 10 * Exponent * log10(d / ReferenceDistance) + X
+//
+// where X is a zero mean Gaussian with standard deviation of Sigma dB.
+type LogDistance struct {
+	ReferenceLoss     float64 // dB
+	ReferenceDistance float64 // same unit as node positions
+	Exponent          float64
+	Sigma             float64 // dB
+}
+
+// MeanLoss returns path loss at distance dist without shadowing.
+func (l *LogDistance) MeanLoss(dist float64) float64 {
+	if dist < l.ReferenceDistance {
+		dist = l.ReferenceDistance
+	}
+	return l.ReferenceLoss + 10*l.Exponent*math.Log10(dist/l.ReferenceDistance)
+}
+
+// Loss returns path loss at distance dist with shadowing freshly drawn from r.
+func (l *LogDistance) Loss(dist float64, r *random.Rand) float64 {
+	if l.Sigma == 0 {
+		return l.MeanLoss(dist)
+	}
+	return l.MeanLoss(dist) + r.NormFloat64()*l.Sigma
+}
+
+const speedOfLight = 299792458 // m/s
+
+// Wavelength returns the wavelength in meters of a carrier at frequency MHz.
+func Wavelength(frequency float64) float64 {
+	return speedOfLight / (frequency * 1e6)
+}
+
+// FreeSpaceLoss returns Friis free space path loss in dB at distance dist for
+// the given wavelength. Both are in meters.
+func FreeSpaceLoss(dist float64, wavelength float64) float64 {
+	return 20 * math.Log10(4*math.Pi*dist/wavelength)
+}
+
+// TwoRayGround is the two-ray ground reflection model. Up to the crossover
+// distance 4*pi*ht*hr/wavelength, where the direct and reflected rays stop
+// interfering constructively, free space loss is used instead.
+type TwoRayGround struct {
+	Wavelength float64 // meters
+}
+
+// Loss returns path loss in dB between two antennas at heights ht and hr that
+// are dist apart horizontally. All lengths are in meters. An antenna on or
+// below the ground gets nothing.
+func (t *TwoRayGround) Loss(dist float64, ht float64, hr float64) float64 {
+	if ht <= 0 || hr <= 0 {
+		return math.Inf(1)
+	}
+	if dist < t.Wavelength {
+		dist = t.Wavelength
+	}
+	if dist < t.Crossover(ht, hr) {
+		return FreeSpaceLoss(math.Sqrt(dist*dist+(ht-hr)*(ht-hr)), t.Wavelength)
+	}
+	return 40*math.Log10(dist) - 20*math.Log10(ht) - 20*math.Log10(hr)
+}
+
+// Crossover returns the distance beyond which the two-ray model applies.
+func (t *TwoRayGround) Crossover(ht float64, hr float64) float64 {
+	return 4 * math.Pi * ht * hr / t.Wavelength
+}
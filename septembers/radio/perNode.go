@@ -0,0 +1,222 @@
+package radio
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/nodeRef"
+	"github.com/squirrel-land/squirrel"
+)
+
+const PerNodeParametersHelp = `
+  "node_classes":       directory, optional;
+                        Class of nodes by node index or hardware address,
+                        e.g. "node_classes/0": "rsu".
+  "radio_classes":      directory, optional;
+                        Radio parameters of classes of nodes, e.g.
+                        "radio_classes/rsu": {"TxPowerDbm": 33,
+                        "AntennaHeight": 5}. Fields are TxPowerDbm,
+                        TransmissionRange, SensitivityDbm and AntennaHeight;
+                        those left out keep the defaults.
+  "radio_nodes":        directory, optional;
+                        Radio parameters of specific nodes by node index or
+                        hardware address, taking precedence over their class,
+                        e.g. "radio_nodes/3": {"SensitivityDbm": -85}.
+                        With different parameters on both ends, links are
+                        asymmetric. Models based on ranges rather than power
+                        take TxPowerDbm and SensitivityDbm as offsets in dB,
+                        0 by default, stretching the sender's
+                        TransmissionRange accordingly. Models based on power
+                        take them in dBm, with no SensitivityDbm limit by
+                        default, and reject TransmissionRange. AntennaHeight
+                        is added to node height, in the unit of node
+                        positions.
+`
+
+// JSRadio overrides radio parameters of a node or a class of nodes. Fields
+// left nil keep the defaults.
+type JSRadio struct {
+	TxPowerDbm        *float64
+	TransmissionRange *float64
+	SensitivityDbm    *float64
+	AntennaHeight     *float64
+}
+
+// Radio is radio parameters of a node.
+type Radio struct {
+	TxPowerDbm        float64
+	TransmissionRange float64
+	SensitivityDbm    float64 // weakest frame the node receives
+	AntennaHeight     float64
+}
+
+func (r *Radio) apply(o *JSRadio) {
+	if o == nil {
+		return
+	}
+	if o.TxPowerDbm != nil {
+		r.TxPowerDbm = *o.TxPowerDbm
+	}
+	if o.TransmissionRange != nil {
+		r.TransmissionRange = *o.TransmissionRange
+	}
+	if o.SensitivityDbm != nil {
+		r.SensitivityDbm = *o.SensitivityDbm
+	}
+	if o.AntennaHeight != nil {
+		r.AntennaHeight = *o.AntennaHeight
+	}
+}
+
+// PerNode holds radio parameters of each node: defaults, overridden by the
+// class of the node and then by the node itself.
+type PerNode struct {
+	classOf map[string]string   // by node reference
+	classes map[string]*JSRadio // by class
+	nodes   map[string]*JSRadio // by node reference
+
+	positionManager squirrel.PositionManager
+	defaults        Radio
+	radios          []Radio
+	uniform         bool // all nodes have the defaults
+}
+
+// Configure reads the parameters in PerNodeParametersHelp from conf.
+func (p *PerNode) Configure(conf *etcd.Node) error {
+	p.classOf = make(map[string]string)
+	p.classes = make(map[string]*JSRadio)
+	p.nodes = make(map[string]*JSRadio)
+	for _, node := range conf.Nodes {
+		if !node.Dir {
+			continue
+		}
+		if strings.HasSuffix(node.Key, "/node_classes") {
+			for _, n := range node.Nodes {
+				p.classOf[nodeRef.Normalize(n.Key[strings.LastIndex(n.Key, "/")+1:])] = n.Value
+			}
+		} else if strings.HasSuffix(node.Key, "/radio_classes") {
+			if err := parseRadios(node, p.classes, false); err != nil {
+				return err
+			}
+		} else if strings.HasSuffix(node.Key, "/radio_nodes") {
+			if err := parseRadios(node, p.nodes, true); err != nil {
+				return err
+			}
+		}
+	}
+	for ref, class := range p.classOf {
+		if _, ok := p.classes[class]; !ok {
+			return fmt.Errorf("node_classes: %s is of unknown class %s", ref, class)
+		}
+	}
+	return nil
+}
+
+func parseRadios(dir *etcd.Node, into map[string]*JSRadio, byNode bool) error {
+	for _, n := range dir.Nodes {
+		var r JSRadio
+		if err := json.Unmarshal([]byte(n.Value), &r); err != nil {
+			return fmt.Errorf("parsing %s error: %v", n.Key, err)
+		}
+		if r.TransmissionRange != nil && *r.TransmissionRange <= 0 {
+			return fmt.Errorf("%s: TransmissionRange has to be positive", n.Key)
+		}
+		key := n.Key[strings.LastIndex(n.Key, "/")+1:]
+		if byNode {
+			key = nodeRef.Normalize(key)
+		}
+		into[key] = &r
+	}
+	return nil
+}
+
+// Initialize works out parameters of every node, starting from defaults. It
+// returns an error if node_classes or radio_nodes refer to no node.
+func (p *PerNode) Initialize(positionManager squirrel.PositionManager, defaults Radio) error {
+	for ref := range p.classOf {
+		if err := nodeRef.Check(positionManager, ref); err != nil {
+			return fmt.Errorf("node_classes: %v", err)
+		}
+	}
+	for ref := range p.nodes {
+		if err := nodeRef.Check(positionManager, ref); err != nil {
+			return fmt.Errorf("radio_nodes: %v", err)
+		}
+	}
+
+	p.positionManager = positionManager
+	p.defaults = defaults
+	p.uniform = len(p.nodes) == 0 && len(p.classOf) == 0
+	p.radios = make([]Radio, positionManager.Capacity())
+	for i := range p.radios {
+		p.radios[i] = defaults
+		refs := nodeRef.Of(positionManager, i)
+		for _, ref := range refs {
+			if class, ok := p.classOf[ref]; ok {
+				p.radios[i].apply(p.classes[class])
+			}
+		}
+		for _, ref := range refs {
+			p.radios[i].apply(p.nodes[ref])
+		}
+	}
+	return nil
+}
+
+// Of returns radio parameters of node i.
+func (p *PerNode) Of(i int) *Radio {
+	return &p.radios[i]
+}
+
+// Distance returns the distance between antennas of nodes i and j.
+func (p *PerNode) Distance(i int, j int) float64 {
+	dist := p.positionManager.Distance(i, j)
+	ai, aj := p.radios[i].AntennaHeight, p.radios[j].AntennaHeight
+	if ai == aj {
+		return dist
+	}
+	pi, err := p.positionManager.Get(i)
+	if err != nil {
+		return dist
+	}
+	pj, err := p.positionManager.Get(j)
+	if err != nil {
+		return dist
+	}
+	dh := pi.Height - pj.Height
+	dhAntennas := dh + ai - aj
+	return math.Sqrt(math.Max(dist*dist-dh*dh, 0) + dhAntennas*dhAntennas)
+}
+
+// SetsTransmissionRange tells whether any class or node overrides
+// TransmissionRange, which models based on power have no use for.
+func (p *PerNode) SetsTransmissionRange() bool {
+	for _, r := range p.classes {
+		if r.TransmissionRange != nil {
+			return true
+		}
+	}
+	for _, r := range p.nodes {
+		if r.TransmissionRange != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// LinkRange returns the range of the link from src to dst, for models where
+// received power falls with distance^exponent: src's TransmissionRange,
+// stretched by how much src's TxPowerDbm and dst's SensitivityDbm differ from
+// the defaults. Models based on power must not call it, as their default
+// SensitivityDbm may be infinite.
+func (p *PerNode) LinkRange(src int, dst int, exponent float64) float64 {
+	if p.uniform {
+		return p.defaults.TransmissionRange
+	}
+	s, d := &p.radios[src], &p.radios[dst]
+	gain := s.TxPowerDbm - p.defaults.TxPowerDbm - (d.SensitivityDbm - p.defaults.SensitivityDbm)
+	return s.TransmissionRange * math.Pow(10, gain/(10*exponent))
+}
@@ -0,0 +1,77 @@
+package radio
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// Receiver maps received power to a packet delivery probability. A packet of
+// ReferenceSize bytes received at Sensitivity dBm is delivered half of the
+// time; Width says how many dB it takes to go from there to ~73%. Other sizes
+// are scaled as if bits were lost independently.
+type Receiver struct {
+	Sensitivity   float64 // dBm
+	Width         float64 // dB
+	ReferenceSize int     // bytes
+}
+
+const ReceiverParametersHelp = `
+  "sensitivity_dbm":    float64, required;
+                        Received power (dBm) at which a packet of
+                        reference_size bytes is delivered half of the time.
+  "sensitivity_width_db": float64, optional, default 1;
+                        Steepness of the sensitivity curve. Larger values make
+                        delivery probability change slower with power.
+  "reference_size":     int, optional, default 1000;
+                        Packet size in bytes that sensitivity_dbm refers to.
+`
+
+// Configure reads receiver parameters (see ReceiverParametersHelp) from conf.
+func (r *Receiver) Configure(conf *etcd.Node) (err error) {
+	r.Width = 1
+	r.ReferenceSize = 1000
+	found := false
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "sensitivity_dbm") {
+			r.Sensitivity, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+			found = true
+		} else if !node.Dir && strings.HasSuffix(node.Key, "sensitivity_width_db") {
+			r.Width, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "reference_size") {
+			r.ReferenceSize, err = strconv.Atoi(node.Value)
+			if err != nil {
+				return
+			}
+		}
+	}
+	if !found {
+		return errors.New("sensitivity_dbm is missing from config")
+	}
+	if r.Width <= 0 {
+		return errors.New("sensitivity_width_db has to be greater than 0")
+	}
+	if r.ReferenceSize <= 0 {
+		return errors.New("reference_size has to be greater than 0")
+	}
+	return
+}
+
+// DeliveryProbability returns the probability that a packet of size bytes
+// received at rxPower dBm gets through.
+func (r *Receiver) DeliveryProbability(rxPower float64, size int) float64 {
+	ref := 1 / (1 + math.Exp(-(rxPower-r.Sensitivity)/r.Width))
+	if size <= 0 {
+		return ref
+	}
+	return math.Pow(ref, float64(size)/float64(r.ReferenceSize))
+}
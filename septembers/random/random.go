@@ -0,0 +1,135 @@
+package random
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+const ParametersHelp = `
+  "seed":               int, optional;
+                        Seed of random numbers, for reproducible runs. Each
+                        node and link draws from its own stream derived from
+                        it, so adding a node doesn't change what the others
+                        draw. Without it, a seed is taken from the time.
+`
+
+// Rand is a random number generator that is safe for concurrent use.
+type Rand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (r *Rand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+func (r *Rand) NormFloat64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.NormFloat64()
+}
+
+func (r *Rand) ExpFloat64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.ExpFloat64()
+}
+
+func (r *Rand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Intn(n)
+}
+
+type streamKey struct {
+	name string
+	a, b int
+}
+
+// Source hands out independent streams of random numbers, all derived from a
+// single seed. A stream is identified by a name, so that different uses in a
+// model don't share one, and by a node or a link.
+type Source struct {
+	seed int64
+
+	mu      sync.Mutex
+	streams map[streamKey]*Rand
+}
+
+func New(seed int64) *Source {
+	return &Source{seed: seed, streams: make(map[streamKey]*Rand)}
+}
+
+// Configure returns a Source seeded by "seed" in conf.
+func Configure(conf *etcd.Node) (s *Source, err error) {
+	if conf == nil {
+		return nil, errors.New("random: conf (*etcd.Node) is nil")
+	}
+	seed := time.Now().UnixNano()
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "/seed") {
+			seed, err = strconv.ParseInt(node.Value, 10, 64)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return New(seed), nil
+}
+
+// Seed returns the seed s was created with.
+func (s *Source) Seed() int64 {
+	return s.seed
+}
+
+// Stream returns the stream named name that isn't bound to a node or link.
+func (s *Source) Stream(name string) *Rand {
+	return s.stream(streamKey{name, -1, -1})
+}
+
+// Node returns the stream named name of node id.
+func (s *Source) Node(name string, id int) *Rand {
+	return s.stream(streamKey{name, id, -1})
+}
+
+// Link returns the stream named name of the link from src to dst.
+func (s *Source) Link(name string, src int, dst int) *Rand {
+	return s.stream(streamKey{name, src, dst})
+}
+
+func (s *Source) stream(key streamKey) *Rand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.streams[key]
+	if !ok {
+		r = &Rand{r: rand.New(rand.NewSource(s.derive(key)))}
+		s.streams[key] = r
+	}
+	return r
+}
+
+// derive mixes the seed and key into the seed of a stream.
+func (s *Source) derive(key streamKey) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(key.name))
+	x := uint64(s.seed) ^ h.Sum64()
+	x = splitmix(x ^ uint64(int64(key.a)))
+	x = splitmix(x ^ uint64(int64(key.b)))
+	return int64(x)
+}
+
+func splitmix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,174 @@
+package twoRayGround
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/models/septembers/radio"
+	"github.com/squirrel-land/models/septembers/random"
+	"github.com/squirrel-land/squirrel"
+)
+
+type twoRayGround struct {
+	positionManager squirrel.PositionManager
+
+	txPower       float64 // dBm
+	gains         float64 // dB; tx gain + rx gain - system loss
+	unitsPerMeter float64
+	heightOffset  float64 // meters
+	model         radio.TwoRayGround
+	receiver      radio.Receiver
+	fading        radio.Fading
+	random        *random.Source
+}
+
+func CreateSeptember() squirrel.September {
+	return &twoRayGround{}
+}
+
+func (t *twoRayGround) ParametersHelp() string {
+	return `TwoRayGround is a september that computes received power with the two-ray
+ground reflection model, using antenna heights from node positions. Below the
+crossover distance 4*pi*ht*hr/lambda it uses free space path loss instead.
+Packets are delivered based on a receiver sensitivity curve. It does not
+consider interference.
+
+  "tx_power_dbm":       float64, required;
+                        Transmission power in dBm.
+  "frequency_mhz":      float64, required;
+                        Carrier frequency in MHz.
+  "tx_gain_dbi":        float64, optional, default 0;
+                        Antenna gain of the transmitter.
+  "rx_gain_dbi":        float64, optional, default 0;
+                        Antenna gain of the receiver.
+  "system_loss_db":     float64, optional, default 0;
+                        System loss not related to propagation.
+  "units_per_meter":    float64, optional, default 1;
+                        Number of position units in one meter.
+  "antenna_height":     float64, optional, default 0;
+                        Meters added to each node's height, e.g. for antennas
+                        mounted above the nodes' positions.` +
+		radio.ReceiverParametersHelp + radio.FadingParametersHelp + random.ParametersHelp
+}
+
+func (t *twoRayGround) Configure(conf *etcd.Node) (err error) {
+	if conf == nil {
+		err = errors.New("TwoRayGround: conf (*etcd.Node) is nil")
+		return
+	}
+
+	t.unitsPerMeter = 1
+	var txPowerFound bool
+	var frequency, txGain, rxGain, systemLoss float64
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "tx_power_dbm") {
+			t.txPower, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+			txPowerFound = true
+		} else if !node.Dir && strings.HasSuffix(node.Key, "frequency_mhz") {
+			frequency, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "tx_gain_dbi") {
+			txGain, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "rx_gain_dbi") {
+			rxGain, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "system_loss_db") {
+			systemLoss, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "units_per_meter") {
+			t.unitsPerMeter, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		} else if !node.Dir && strings.HasSuffix(node.Key, "antenna_height") {
+			t.heightOffset, err = strconv.ParseFloat(node.Value, 64)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	var errorParameters []string
+	if !txPowerFound {
+		errorParameters = append(errorParameters, "tx_power_dbm")
+	}
+	if frequency <= 0 {
+		errorParameters = append(errorParameters, "frequency_mhz")
+	}
+	if t.unitsPerMeter <= 0 {
+		errorParameters = append(errorParameters, "units_per_meter")
+	}
+	if len(errorParameters) != 0 {
+		err = fmt.Errorf("parameter(s) missing or invalid: %v", errorParameters)
+		return
+	}
+
+	t.gains = txGain + rxGain - systemLoss
+	t.model.Wavelength = radio.Wavelength(frequency)
+	if err = t.receiver.Configure(conf); err != nil {
+		return
+	}
+	if err = t.fading.Configure(conf); err != nil {
+		return
+	}
+	t.random, err = random.Configure(conf)
+	return
+}
+
+func (t *twoRayGround) Initialize(positionManager squirrel.PositionManager) {
+	t.positionManager = positionManager
+	t.fading.Initialize(positionManager)
+}
+
+func (t *twoRayGround) SendUnicast(source int, destination int, size int) bool {
+	return t.isToBeDelivered(source, destination, size)
+}
+
+func (t *twoRayGround) SendBroadcast(source int, size int, underlying []int) []int {
+	count := 0
+	for _, i := range t.positionManager.Enabled() {
+		if i != source && t.isToBeDelivered(source, i, size) {
+			underlying[count] = i
+			count++
+		}
+	}
+	return underlying[:count]
+}
+
+func (t *twoRayGround) isToBeDelivered(id1 int, id2 int, size int) bool {
+	if !(t.positionManager.IsEnabled(id1) && t.positionManager.IsEnabled(id2)) {
+		return false
+	}
+	p1, err := t.positionManager.Get(id1)
+	if err != nil {
+		return false
+	}
+	p2, err := t.positionManager.Get(id2)
+	if err != nil {
+		return false
+	}
+
+	// the model needs horizontal distance and heights separately, in meters
+	dist := math.Hypot(p1.X-p2.X, p1.Y-p2.Y) / t.unitsPerMeter
+	ht := p1.Height/t.unitsPerMeter + t.heightOffset
+	hr := p2.Height/t.unitsPerMeter + t.heightOffset
+
+	rxPower := t.txPower + t.gains - t.model.Loss(dist, ht, hr) + t.fading.GainDB(id1, id2)
+	return t.random.Link("rx", id1, id2).Float64() < t.receiver.DeliveryProbability(rxPower, size)
+}
@@ -0,0 +1,82 @@
+package wrapper
+
+import (
+	"time"
+
+	"github.com/squirrel-land/models/septembers/clock"
+	"github.com/squirrel-land/models/septembers/delay"
+	"github.com/squirrel-land/models/septembers/qos"
+	"github.com/squirrel-land/squirrel"
+)
+
+// Septembers that wrap others implement delay.September, qos.September and
+// clock.User by passing the calls on with the functions below, so that delays,
+// access categories and clocks of the inner septembers aren't lost. Inner
+// septembers without them deliver without delay, ignore access categories and
+// keep their own clocks.
+
+// SetClock calls inner's SetClock if it's a clock.User.
+func SetClock(inner squirrel.September, c clock.Clock) {
+	if u, ok := inner.(clock.User); ok {
+		u.SetClock(c)
+	}
+}
+
+// SendUnicastWithDelay calls inner's SendUnicastWithDelay if it's a
+// delay.September, or SendUnicast otherwise.
+func SendUnicastWithDelay(inner squirrel.September, source int, destination int, size int) (bool, time.Duration) {
+	if d, ok := inner.(delay.September); ok {
+		return d.SendUnicastWithDelay(source, destination, size)
+	}
+	return inner.SendUnicast(source, destination, size), 0
+}
+
+// SendBroadcastWithDelay calls inner's SendBroadcastWithDelay if it's a
+// delay.September, or SendBroadcast otherwise.
+func SendBroadcastWithDelay(inner squirrel.September, source int, size int, underlying []int, delays []time.Duration) ([]int, []time.Duration) {
+	if d, ok := inner.(delay.September); ok {
+		return d.SendBroadcastWithDelay(source, size, underlying, delays)
+	}
+	delivered := inner.SendBroadcast(source, size, underlying)
+	for i := range delivered {
+		delays[i] = 0
+	}
+	return delivered, delays[:len(delivered)]
+}
+
+// SendUnicastWithCategory calls inner's SendUnicastWithCategory if it's a
+// qos.September, or SendUnicastWithDelay otherwise.
+func SendUnicastWithCategory(inner squirrel.September, source int, destination int, size int, ac qos.AccessCategory) (bool, time.Duration) {
+	if q, ok := inner.(qos.September); ok {
+		return q.SendUnicastWithCategory(source, destination, size, ac)
+	}
+	return SendUnicastWithDelay(inner, source, destination, size)
+}
+
+// SendBroadcastWithCategory calls inner's SendBroadcastWithCategory if it's a
+// qos.September, or SendBroadcastWithDelay otherwise.
+func SendBroadcastWithCategory(inner squirrel.September, source int, size int, ac qos.AccessCategory, underlying []int, delays []time.Duration) ([]int, []time.Duration) {
+	if q, ok := inner.(qos.September); ok {
+		return q.SendBroadcastWithCategory(source, size, ac, underlying, delays)
+	}
+	return SendBroadcastWithDelay(inner, source, size, underlying, delays)
+}
+
+// Filter keeps receivers in delivered for which keep returns true, along with
+// their delays unless delays is nil. It works in place.
+func Filter(delivered []int, delays []time.Duration, keep func(i int) bool) ([]int, []time.Duration) {
+	count := 0
+	for k, i := range delivered {
+		if keep(i) {
+			delivered[count] = i
+			if delays != nil {
+				delays[count] = delays[k]
+			}
+			count++
+		}
+	}
+	if delays != nil {
+		delays = delays[:count]
+	}
+	return delivered[:count], delays
+}
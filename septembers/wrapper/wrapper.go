@@ -0,0 +1,52 @@
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// Factory creates a september by its name, e.g. "DistanceBased". Septembers
+// that wrap other septembers get one when they are created.
+type Factory func(name string) (squirrel.September, error)
+
+const InnerParametersHelp = `
+  "inner":              string, required;
+                        Name of the september to wrap. Its delays and access
+                        categories, if it has them, are passed through.
+  "inner_config":       directory, optional;
+                        Parameters of the inner september.
+`
+
+// ConfigureInner creates the september named by conf's "inner" key with
+// factory, and configures it with the "inner_config" subtree.
+func ConfigureInner(conf *etcd.Node, factory Factory) (inner squirrel.September, err error) {
+	var name string
+	innerConf := &etcd.Node{Dir: true}
+	for _, node := range conf.Nodes {
+		if !node.Dir && strings.HasSuffix(node.Key, "/inner") {
+			name = node.Value
+		} else if node.Dir && strings.HasSuffix(node.Key, "/inner_config") {
+			innerConf = node
+		}
+	}
+	if name == "" {
+		return nil, errors.New("inner is missing from config")
+	}
+	return Configure(name, innerConf, factory)
+}
+
+// Configure creates the september called name with factory and configures it
+// with conf.
+func Configure(name string, conf *etcd.Node, factory Factory) (s squirrel.September, err error) {
+	if s, err = factory(name); err != nil {
+		return
+	}
+	if err = s.Configure(conf); err != nil {
+		err = fmt.Errorf("%s: %v", name, err)
+	}
+	return
+}